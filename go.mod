@@ -2,5 +2,5 @@ module github.com/sibber5/go-problemdetails
 
 go 1.25.0
 
-// Test dependencies
+// Used by tests, and by the pdchi package only.
 require github.com/go-chi/chi/v5 v5.2.3
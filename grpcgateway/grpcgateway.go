@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+// Package grpcgateway contains an error handler that writes the errors of the grpc-gateway runtime as problem details.
+// It's a separate module so that the problemdetails module itself doesn't depend on grpc-gateway or gRPC.
+package grpcgateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/sibber5/go-problemdetails/problemdetails"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Option configures the handler returned by ProtoErrorHandler.
+type Option func(*handler)
+
+// WithErrorDetails makes the handler serialize the details of gRPC statuses (google.rpc.Status.details) in the "details" extension member,
+// as an array of their protojson representations (each with an "@type" member).
+// Only enable it if the details are meant for clients, since they're written as is.
+func WithErrorDetails() Option {
+	return func(h *handler) {
+		h.errorDetails = true
+	}
+}
+
+type handler struct {
+	pdw          *problemdetails.Writer
+	errorDetails bool // Whether to write the status details in the "details" extension member.
+}
+
+// ProtoErrorHandler returns a grpc-gateway error handler that writes errors as problem details with pdw (which may be nil) instead of the
+// gateway's default body, so that gateway and native HTTP endpoints share one error format. Use it with `runtime.WithErrorHandler`.
+//
+// The HTTP status is mapped from the gRPC code as by runtime.HTTPStatusFromCode (or taken from a *runtime.HTTPStatusError), the code's name
+// (e.g. "NotFound") is the problem's code, and the status message is its detail. Errors that aren't gRPC statuses are written without a detail.
+// The status details are only written with WithErrorDetails.
+func ProtoErrorHandler(pdw *problemdetails.Writer, opts ...Option) runtime.ErrorHandlerFunc {
+	if pdw == nil {
+		pdw = &problemdetails.Writer{}
+	}
+	h := &handler{pdw: pdw}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h.handle
+}
+
+func (h *handler) handle(_ context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	httpStatus := 0
+	var httpErr *runtime.HTTPStatusError
+	if errors.As(err, &httpErr) {
+		httpStatus, err = httpErr.HTTPStatus, httpErr.Err
+	}
+
+	s, ok := status.FromError(err)
+	if httpStatus == 0 {
+		httpStatus = runtime.HTTPStatusFromCode(s.Code())
+	}
+	pd := &problemdetails.ProblemDetails{Status: httpStatus, Code: s.Code().String()}
+	if ok {
+		pd.Detail = s.Message()
+		if h.errorDetails {
+			if details := marshalDetails(s); len(details) > 0 {
+				pd.Extensions = map[string]any{"details": details}
+			}
+		}
+	}
+
+	h.pdw.WriteProblem(w, r, pd.WithCause(err))
+}
+
+// marshalDetails returns the protojson representations of the details of s, skipping those that can't be marshaled.
+func marshalDetails(s *status.Status) []json.RawMessage {
+	var details []json.RawMessage
+	for _, detail := range s.Proto().GetDetails() {
+		if b, err := protojson.Marshal(detail); err == nil {
+			details = append(details, b)
+		}
+	}
+	return details
+}
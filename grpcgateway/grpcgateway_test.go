@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package grpcgateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/sibber5/go-problemdetails/problemdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoErrorHandler(t *testing.T) {
+	withDetails, err := status.New(codes.InvalidArgument, "bad name").WithDetails(wrapperspb.String("too long"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		err    error
+		status int
+		body   string
+	}{
+		{"status", nil, status.Error(codes.NotFound, "no such user"), http.StatusNotFound,
+			`{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","detail":"no such user","code":"NotFound"}` + "\n"},
+		{"details omitted", nil, withDetails.Err(), http.StatusBadRequest,
+			`{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","detail":"bad name","code":"InvalidArgument"}` + "\n"},
+		{"details", []Option{WithErrorDetails()}, withDetails.Err(), http.StatusBadRequest,
+			`{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","detail":"bad name","code":"InvalidArgument","details":[{"@type":"type.googleapis.com/google.protobuf.StringValue","value":"too long"}]}` + "\n"},
+		{"http status", nil, &runtime.HTTPStatusError{HTTPStatus: http.StatusMethodNotAllowed, Err: status.Error(codes.Unimplemented, "Method Not Allowed")}, http.StatusMethodNotAllowed,
+			`{"type":"about:blank","status":405,"title":"Method Not Allowed","detail":"Method Not Allowed","code":"Unimplemented"}` + "\n"},
+		{"not a status", nil, errors.New("connection refused to 10.0.0.1"), http.StatusInternalServerError,
+			`{"type":"https://problems-registry.smartbear.com/server-error","status":500,"title":"Internal Server Error","code":"Unknown"}` + "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := ProtoErrorHandler(nil, test.opts...)
+			w := httptest.NewRecorder()
+			handler(context.Background(), runtime.NewServeMux(), &runtime.JSONPb{}, w, httptest.NewRequest("GET", "/users/1", nil), test.err)
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, w.Code)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json; charset=utf-8" {
+				t.Fatalf("expected a problem details content type, got %q", contentType)
+			}
+			if body := w.Body.String(); body != test.body {
+				t.Fatalf("expected body %s, got %s", test.body, body)
+			}
+		})
+	}
+}
+
+func TestProtoErrorHandlerCause(t *testing.T) {
+	handler := ProtoErrorHandler(&problemdetails.Writer{})
+	err := status.Error(codes.Unavailable, "try again later")
+
+	var pd *problemdetails.ProblemDetails
+	problemdetails.ProblemDetailsContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(r.Context(), nil, &runtime.JSONPb{}, w, r, err)
+		pd = problemdetails.FromContext(r.Context()).Details()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if pd == nil || pd.Status != http.StatusServiceUnavailable || !errors.Is(pd, err) {
+		t.Fatalf("expected the recorded problem to be a 503 caused by the status error, got %v", pd)
+	}
+}
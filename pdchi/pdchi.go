@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+// Package pdchi contains helpers for using problemdetails with the chi router.
+// It's a separate package so that the problemdetails package itself doesn't depend on chi.
+package pdchi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// InstanceFromChi returns the route pattern that chi matched for r (e.g. "/users/{id}"), or r.URL.Path if r wasn't routed by chi.
+// Route patterns are more stable than the raw paths, so they make instances that can be aggregated, e.g. for metrics.
+//
+// Use it as the default instance of problem details responses with `&problemdetails.Writer{GetInstance: pdchi.InstanceFromChi}`.
+func InstanceFromChi(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return r.URL.Path
+}
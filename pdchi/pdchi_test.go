@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package pdchi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sibber5/go-problemdetails/problemdetails"
+)
+
+func TestInstanceFromChi(t *testing.T) {
+	pdw := &problemdetails.Writer{GetInstance: InstanceFromChi}
+	c := problemdetails.NewConfig(problemdetails.WithWriter(pdw))
+
+	r := chi.NewRouter()
+	r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Route("/users", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			pdw.Write(w, r, http.StatusNotFound, "", "")
+		})
+		r.Get("/{id}/avatar", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGone)
+		})
+	})
+
+	for path, instance := range map[string]string{
+		"/users/12345":        "/users/{id}",
+		"/users/12345/avatar": "/users/{id}/avatar",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		pd := &problemdetails.ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		if pd.Instance != instance {
+			t.Fatalf("expected instance %q for %s, got %q", instance, path, pd.Instance)
+		}
+	}
+
+	if instance := InstanceFromChi(httptest.NewRequest("GET", "/users/12345", nil)); instance != "/users/12345" {
+		t.Fatalf("expected the path as the instance without a chi context, got %q", instance)
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// CBOR major types, see RFC 8949 section 3.1.
+const (
+	cborUint  byte = 0
+	cborNeg   byte = 1
+	cborText  byte = 3
+	cborArray byte = 4
+	cborMap   byte = 5
+)
+
+// marshalCBORBody serializes pd in CBOR (RFC 8949), with the same data model and member order as the JSON format:
+// objects are maps with text string keys, and numbers are integers if they're integral and fit in 64 bits, otherwise floats.
+func marshalCBORBody(pd *ProblemDetails) ([]byte, error) {
+	v, err := marshalOrdered(pd)
+	if err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, v)
+}
+
+func appendCBOR(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []member:
+		b = appendCBORHead(b, cborMap, uint64(len(v)))
+		for _, m := range v {
+			b = appendCBORHead(b, cborText, uint64(len(m.name)))
+			b = append(b, m.name...)
+			var err error
+			if b, err = appendCBOR(b, m.value); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = appendCBOR(b, item); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		b = appendCBORHead(b, cborText, uint64(len(v)))
+		b = append(b, v...)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n >= 0 {
+				return appendCBORHead(b, cborUint, uint64(n)), nil
+			}
+			return appendCBORHead(b, cborNeg, uint64(-1-n)), nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendCBORHead(b, cborUint, n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("problemdetails: invalid number %q", v)
+		}
+		b = append(b, 0xfb) // Double-precision float.
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+	case bool:
+		if v {
+			b = append(b, 0xf5)
+		} else {
+			b = append(b, 0xf4)
+		}
+	case nil:
+		b = append(b, 0xf6)
+	default:
+		return nil, fmt.Errorf("problemdetails: unexpected value of type %T", v)
+	}
+	return b, nil
+}
+
+// appendCBORHead appends the head of a data item with the given major type and argument (its value, length or number of items).
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+	}
+}
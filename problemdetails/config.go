@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Config is the configuration shared by the middlewares and the write functions, so it can be configured once and reused.
+// Settings that don't apply to a function are ignored by it, as documented on each field.
+//
+// The zero value is not ready to use, create one with DefaultConfig or NewConfig.
+type Config struct {
+	Writer        *Writer      // The writer used to write problem details responses. If nil, the default writer (see Default) at the time of writing is used.
+	Logger        *slog.Logger // The logger used for errors that can't be reported in a response. If nil, slog.Default() at the time of logging is used. Used by Recoverer only.
+	Format        Format       // The format in which problem details responses are serialized.
+	StackFrameIdx int          // The index of the caller in the stack frame to include in the details of recovered panics, see Recoverer. If < 0 it won't be included. Used by Recoverer only.
+	SuccessStatus int          // The status code of successful responses. Used by JSONHandler only.
+	PanicType     string       // The type URI of problem details responses for recovered panics. If "", the default type for HTTP 500 is used. Used by Recoverer only.
+	PanicTitle    string       // The title of problem details responses for recovered panics. If "", the default title for HTTP 500 is used. Used by Recoverer only.
+	OriginalBody  int          // If > 0, the converter also converts error responses with bodies of up to this many bytes, including the original body in the problem (see WithOriginalBody). Used by ProblemDetailsConverter only.
+
+	// If PanicBodyBytes > 0 and RedactPanicBody isn't nil, up to this many bytes of the request body are captured as the handler reads it,
+	// and on panic they're redacted with RedactPanicBody and recorded in the `*problemdetails.Context` (see Context.PanicBody), and logged if the response
+	// was already committed. See WithPanicBody. Used by Recoverer only.
+	PanicBodyBytes  int
+	RedactPanicBody func(body []byte) string
+
+	// The headers of the original response that are deleted from converted responses, since they describe the original body.
+	// By default Content-Encoding, Vary, and Content-Length. Headers that are also in PreserveHeaders are not deleted.
+	// Note that preserving Content-Encoding or Content-Length usually results in an invalid response. Used by ProblemDetailsConverter only.
+	StripHeaders    []string
+	PreserveHeaders []string
+
+	// The response header with which handlers opt a response out of conversion, by setting it to any non-empty value before writing it.
+	// The header is deleted before the response is sent. By default X-Skip-Problem-Conversion. If "", responses can't opt out.
+	// Used by ProblemDetailsConverter only.
+	SkipConversionHeader string
+
+	// A function that's called for every response intercepted by the converter, with its status, whether it was converted, and how long the
+	// handler took, e.g. to track the ratio of error responses that needed conversion. Used by ProblemDetailsConverter only.
+	OnConvert func(r *http.Request, status int, converted bool, duration time.Duration)
+
+	// Whether problem details response bodies are serialized in a legacy (non-RFC 9457) shape, for clients that haven't migrated yet:
+	// for all requests if LegacyShape is true, or for the requests with a true value (e.g. "1" or "true") in the LegacyShapeHeader header.
+	// The body is the JSON serialization of LegacyMapper's result (by default `{"error": "<detail or title>", "code": "<code>"}`),
+	// with the LegacyContentType Content-Type (by default the one of FormatJSON, whatever Format is). By default, bodies always have the RFC 9457 shape.
+	LegacyShape       bool
+	LegacyShapeHeader string
+	LegacyMapper      func(pd *ProblemDetails) any
+	LegacyContentType string
+
+	// The key of the `*problemdetails.Context` in request contexts (see Config.ProblemDetailsContext), e.g. to run multiple independent instances.
+	// If nil, CtxKey is used. The top-level functions and the Writer methods always use CtxKey.
+	ContextKey any
+
+	// A function that returns the status of a response for which WriteHeader wasn't called, or 0 to use the default (200).
+	// It's called with the request as received by the converter (so values that inner middlewares add to its context aren't visible)
+	// and the response header, when the status is first needed. Used by ProblemDetailsConverter only.
+	ImpliedStatus func(r *http.Request, header http.Header) int
+}
+
+// Option configures a Config.
+type Option func(*Config)
+
+// DefaultConfig returns a new Config with the default settings, which the top-level functions Recoverer, ProblemDetailsConverter, and Write use.
+func DefaultConfig() *Config {
+	return &Config{
+		Format:               FormatJSON,
+		StackFrameIdx:        -1,
+		SuccessStatus:        http.StatusOK,
+		StripHeaders:         []string{"Content-Encoding", "Vary", "Content-Length"},
+		SkipConversionHeader: "X-Skip-Problem-Conversion",
+	}
+}
+
+// NewConfig returns a new Config with the default settings and opts applied in order.
+func NewConfig(opts ...Option) *Config {
+	c := DefaultConfig()
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithWriter sets the writer used to write problem details responses.
+func WithWriter(pdw *Writer) Option {
+	return func(c *Config) { c.Writer = pdw }
+}
+
+// WithLogger sets the logger used for errors that can't be reported in a response. Used by Recoverer only.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) { c.Logger = logger }
+}
+
+// WithFormat sets the format in which problem details responses are serialized.
+func WithFormat(format Format) Option {
+	return func(c *Config) { c.Format = format }
+}
+
+// WithStackFrames sets the index of the caller in the stack frame to include in the details of recovered panics, see Recoverer.
+// If < 0 it won't be included. Used by Recoverer only.
+func WithStackFrames(stackFrameIdx int) Option {
+	return func(c *Config) { c.StackFrameIdx = stackFrameIdx }
+}
+
+// WithSuccessStatus sets the status code of successful responses, e.g. http.StatusCreated. Used by JSONHandler only.
+func WithSuccessStatus(status int) Option {
+	return func(c *Config) { c.SuccessStatus = status }
+}
+
+// WithPanicType sets the type URI and title of problem details responses for recovered panics,
+// e.g. to distinguish them from other HTTP 500 responses. If "", the defaults for HTTP 500 are used. Used by Recoverer only.
+func WithPanicType(typeUri string, title string) Option {
+	return func(c *Config) {
+		c.PanicType = typeUri
+		c.PanicTitle = title
+	}
+}
+
+// WithPanicBody makes the recoverer capture up to maxBytes bytes of each request body as the handler reads it (the handler still reads
+// the full body), to help reproduce panics. On panic, the captured snippet is passed to redact, and the result is recorded in
+// the `*problemdetails.Context` (see Context.PanicBody), and logged if the response was already committed. It's never written to the response.
+//
+// Request bodies often contain credentials and personal data, so this should stay disabled unless needed, and redact is responsible for
+// removing anything that mustn't end up in logs. The raw body is never recorded: if redact is nil, no body is captured. The capture is bounded by maxBytes, but it's done for every request. Used by Recoverer only.
+func WithPanicBody(maxBytes int, redact func(body []byte) string) Option {
+	return func(c *Config) {
+		c.PanicBodyBytes = maxBytes
+		c.RedactPanicBody = redact
+	}
+}
+
+// WithImpliedStatus sets the function that returns the status of a response for which WriteHeader wasn't called, or 0 to use the default (200).
+// This lets the converter recognize error responses whose handlers forgot to call WriteHeader. Used by ProblemDetailsConverter only.
+//
+// If the handler wrote a body, it's passed through with the implied status. Otherwise the response is converted if the implied status calls for it.
+func WithImpliedStatus(fn func(r *http.Request, header http.Header) int) Option {
+	return func(c *Config) { c.ImpliedStatus = fn }
+}
+
+// WithStripHeaders sets the headers of the original response that are deleted from converted responses, replacing the defaults
+// (Content-Encoding, Vary, and Content-Length), e.g. to also delete a stale ETag. Used by ProblemDetailsConverter only.
+func WithStripHeaders(names ...string) Option {
+	return func(c *Config) { c.StripHeaders = names }
+}
+
+// WithPreserveHeaders sets headers that are never deleted from converted responses, even if they're in the headers set with WithStripHeaders.
+// Used by ProblemDetailsConverter only.
+func WithPreserveHeaders(names ...string) Option {
+	return func(c *Config) { c.PreserveHeaders = names }
+}
+
+// WithSkipConversionHeader sets the response header with which handlers opt a response out of conversion (by default X-Skip-Problem-Conversion),
+// e.g. for error responses with their own schema. The header is deleted before the response is sent. If "", responses can't opt out.
+// Used by ProblemDetailsConverter only.
+func WithSkipConversionHeader(name string) Option {
+	return func(c *Config) { c.SkipConversionHeader = name }
+}
+
+// WithOriginalBody makes the converter also convert error responses whose handler wrote a body of up to maxBytes bytes (responses with larger
+// bodies are passed through), instead of only the ones without a body. If the original body is JSON (with a JSON Content-Type), it's included
+// as is in the "original" extension member of the problem details response, otherwise it's used as its detail.
+// The bodies are buffered until the handler returns, so maxBytes should be small. Used by ProblemDetailsConverter only.
+func WithOriginalBody(maxBytes int) Option {
+	return func(c *Config) { c.OriginalBody = maxBytes }
+}
+
+// WithOnConvert sets the function that's called for every response intercepted by the converter, including the ones that are passed through,
+// with its status (200 if none was written), whether it was converted, and how long the handler took. It's called after the response
+// is written (or passed through), and after the callback of ProblemDetailsConverter. Used by ProblemDetailsConverter only.
+func WithOnConvert(fn func(r *http.Request, status int, converted bool, duration time.Duration)) Option {
+	return func(c *Config) { c.OnConvert = fn }
+}
+
+// WithLegacyShape sets whether problem details response bodies are serialized in a legacy shape for all requests, and the function
+// that maps problems to it, e.g. to ease migrating clients that expect `{"error": "...", "code": "..."}` bodies to RFC 9457 incrementally.
+// If mapper is nil, the body is `{"error": "<detail or title>", "code": "<code>"}`, with the code omitted if empty.
+// Use WithLegacyShapeHeader to only use it for clients that ask for it. By default, bodies always have the RFC 9457 shape.
+func WithLegacyShape(enabled bool, mapper func(pd *ProblemDetails) any) Option {
+	return func(c *Config) {
+		c.LegacyShape = enabled
+		c.LegacyMapper = mapper
+	}
+}
+
+// WithLegacyShapeHeader sets the request header with which clients ask for the legacy shape (see WithLegacyShape) when it's not enabled
+// for all requests, by sending a true value like "1" or "true".
+func WithLegacyShapeHeader(name string) Option {
+	return func(c *Config) { c.LegacyShapeHeader = name }
+}
+
+// WithLegacyContentType sets the Content-Type of response bodies in the legacy shape (see WithLegacyShape), e.g. "application/json".
+// If "", the content type of FormatJSON ("application/problem+json; charset=utf-8") is used, since legacy bodies are always JSON.
+func WithLegacyContentType(contentType string) Option {
+	return func(c *Config) { c.LegacyContentType = contentType }
+}
+
+// WithContextKey sets the key of the `*problemdetails.Context` in request contexts, which is used by c.ProblemDetailsContext, c.FromContext,
+// and the writes through c. The key should be of an unexported type, as with any context key.
+func WithContextKey(key any) Option {
+	return func(c *Config) { c.ContextKey = key }
+}
+
+// Writes a problem details http response using c.Writer, serialized in c.Format (or the legacy shape, see WithLegacyShape). See Writer.Write.
+func (c *Config) Write(w http.ResponseWriter, r *http.Request, status int, detail string, code string, errors ...Error) {
+	c.writer().write(w, r, c.FromContext(r.Context()), c.encoding(r), status, detail, code, errors)
+}
+
+// Writes pd as a problem details http response using c.Writer, serialized in c.Format (or the legacy shape, see WithLegacyShape). See Writer.WriteProblem.
+func (c *Config) WriteProblem(w http.ResponseWriter, r *http.Request, pd *ProblemDetails) {
+	c.writer().writeProblem(w, r, c.FromContext(r.Context()), c.encoding(r), pd)
+}
+
+// Writes err as a problem details http response using c.Writer, serialized in c.Format (or the legacy shape, see WithLegacyShape). See Writer.WriteError.
+func (c *Config) WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	c.writer().writeError(w, r, c.FromContext(r.Context()), c.encoding(r), err)
+}
+
+func (c *Config) writer() *Writer {
+	if c.Writer != nil {
+		return c.Writer
+	}
+	return Default()
+}
+
+// encoding returns how the bodies of problem details responses to r are serialized: in c.Format, or in the legacy shape if it applies to r.
+func (c *Config) encoding(r *http.Request) encoding {
+	enc := encoding{format: c.Format}
+	legacy := c.LegacyShape
+	if !legacy && c.LegacyShapeHeader != "" {
+		legacy, _ = strconv.ParseBool(r.Header.Get(c.LegacyShapeHeader))
+	}
+	if legacy {
+		enc.legacy = c.LegacyMapper
+		if enc.legacy == nil {
+			enc.legacy = legacyError
+		}
+		enc.contentType = c.LegacyContentType
+	}
+	return enc
+}
+
+// legacyError is the default legacy shape of problem details response bodies.
+func legacyError(pd *ProblemDetails) any {
+	return struct {
+		Error string `json:"error"`
+		Code  string `json:"code,omitempty"`
+	}{cmp.Or(pd.Detail, pd.Title), pd.Code}
+}
+
+func (c *Config) contextKey() any {
+	if c.ContextKey != nil {
+		return c.ContextKey
+	}
+	return CtxKey
+}
+
+func (c *Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNewConfig(t *testing.T) {
+	assertEqual(t, DefaultConfig(), &Config{
+		Format:               FormatJSON,
+		StackFrameIdx:        -1,
+		SuccessStatus:        http.StatusOK,
+		StripHeaders:         []string{"Content-Encoding", "Vary", "Content-Length"},
+		SkipConversionHeader: "X-Skip-Problem-Conversion",
+	})
+
+	pdw := &Writer{}
+	logger := slog.New(slog.DiscardHandler)
+	c := NewConfig(WithWriter(pdw), WithLogger(logger), WithFormat(FormatJSON), WithStackFrames(1), WithStackFrames(2))
+
+	assertEqual(t, c.Writer == pdw, true)
+	assertEqual(t, c.Logger == logger, true)
+	assertEqual(t, c.StackFrameIdx, 2)
+}
+
+func TestConfigShared(t *testing.T) {
+	logs := &strings.Builder{}
+	c := NewConfig(
+		WithWriter(&Writer{GetRequestID: func(*http.Request) string { return "req" }}),
+		WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+	)
+
+	r := chi.NewRouter()
+	r.Use(c.Recoverer())
+	r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/write", func(w http.ResponseWriter, r *http.Request) {
+		c.Write(w, r, http.StatusBadRequest, "", "")
+	})
+	r.Get("/convert", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/panic", panickingHandler)
+	r.Get("/committed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic(panicMessage)
+	})
+
+	for _, path := range []string{"/write", "/convert", "/panic"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, pd.RequestId, "req")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/committed", nil))
+
+	assertEqual(t, w.Body.String(), "partial")
+	if !strings.Contains(logs.String(), panicMessage) {
+		t.Fatal("expected the panic to be logged using the configured logger, got: " + logs.String())
+	}
+}
+
+func TestConfigPanicType(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(NewConfig(WithPanicType("https://example.com/probs/internal", "Unexpected Error")).Recoverer())
+	r.Get("/", panickingHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, w.Body.String(), `{"type":"https://example.com/probs/internal","status":500,"title":"Unexpected Error","detail":"panic: 'foo'"}`+"\n")
+}
+
+func TestConfigLegacyShape(t *testing.T) {
+	newRouter := func(c *Config) *chi.Mux {
+		r := chi.NewRouter()
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			c.Write(w, r, http.StatusConflict, "The order was already shipped.", "ORDER_SHIPPED")
+		})
+		return r
+	}
+	get := func(r *chi.Mux, legacy string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if legacy != "" {
+			req.Header.Set("X-Legacy-Errors", legacy)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	problemBody := `{"type":"` + defaultTypeUri(http.StatusConflict) + `","status":409,"title":"Conflict","detail":"The order was already shipped.","code":"ORDER_SHIPPED"}` + "\n"
+
+	r := newRouter(NewConfig(WithLegacyShapeHeader("X-Legacy-Errors")))
+
+	w := get(r, "")
+	assertEqual(t, w.Code, http.StatusConflict)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), problemBody)
+
+	w = get(r, "false")
+	assertEqual(t, w.Body.String(), problemBody)
+
+	w = get(r, "1")
+	assertEqual(t, w.Code, http.StatusConflict)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), `{"error":"The order was already shipped.","code":"ORDER_SHIPPED"}`+"\n")
+
+	r = newRouter(NewConfig(
+		WithLegacyShape(true, func(pd *ProblemDetails) any { return map[string]any{"message": pd.Title, "status": pd.Status} }),
+		WithLegacyContentType("application/json"),
+	))
+
+	w = get(r, "")
+	assertEqual(t, w.Code, http.StatusConflict)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/json")
+	assertEqual(t, w.Body.String(), `{"message":"Conflict","status":409}`+"\n")
+
+	w = get(newRouter(NewConfig(WithFormat(FormatXML), WithLegacyShape(true, nil))), "")
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), `{"error":"The order was already shipped.","code":"ORDER_SHIPPED"}`+"\n")
+}
@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format is a serialization format for problem details.
+type Format int
+
+const (
+	FormatJSON Format = iota // application/problem+json; charset=utf-8
+	FormatXML                // application/problem+xml; charset=utf-8, as defined in RFC 9457 appendix B.
+	FormatCBOR               // application/problem+cbor, with the same data model as FormatJSON.
+)
+
+// formats maps each Format to its content type and marshaler. Adding a format only takes a constant above and an entry here.
+var formats = [...]struct {
+	contentType string
+	marshal     func(pd *ProblemDetails) ([]byte, error)
+}{
+	FormatJSON: {"application/problem+json; charset=utf-8", marshalJSONBody},
+	FormatXML:  {"application/problem+xml; charset=utf-8", marshalXMLBody},
+	FormatCBOR: {"application/problem+cbor", marshalCBORBody},
+}
+
+// ContentType returns the content type (including the charset for text formats, which is always UTF-8) of problem details serialized
+// in format f, or "" if f is not a valid Format.
+func (f Format) ContentType() string {
+	if f < 0 || int(f) >= len(formats) {
+		return ""
+	}
+	return formats[f].contentType
+}
+
+// Marshal serializes pd in the given format independently of any HTTP response, for e.g. message queues and other transports.
+// It returns the same body that Write would write, along with its content type.
+//
+// If pd.Type is "" it defaults to the type Write would use for pd.Status, and if pd.Title is "" it defaults to StatusText(pd.Status).
+// pd itself is not modified. If pd is nil, an error is returned.
+func Marshal(pd *ProblemDetails, format Format) ([]byte, string, error) {
+	if pd == nil {
+		return nil, "", errors.New("problemdetails: cannot marshal a nil *ProblemDetails")
+	}
+	if format < 0 || int(format) >= len(formats) {
+		return nil, "", fmt.Errorf("problemdetails: unknown format %d", format)
+	}
+
+	if pd.Type == "" || pd.Title == "" {
+		cp := *pd
+		if cp.Type == "" {
+			cp.Type = defaultTypeUri(cp.Status)
+		}
+		if cp.Title == "" {
+			cp.Title = StatusText(cp.Status)
+		}
+		pd = &cp
+	}
+
+	body, err := formats[format].marshal(pd)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, formats[format].contentType, nil
+}
+
+// encoding is how problem details response bodies are serialized: in a format, or in a legacy shape (see Config.LegacyShape).
+type encoding struct {
+	format      Format
+	legacy      func(pd *ProblemDetails) any // If not nil, the body is the JSON serialization of its result instead.
+	contentType string                       // The content type of legacy bodies, which are always JSON. If "", the content type of FormatJSON is used.
+}
+
+func (enc encoding) marshal(pd *ProblemDetails) ([]byte, string, error) {
+	if enc.legacy == nil {
+		return Marshal(pd, enc.format)
+	}
+
+	buf := &bytes.Buffer{}
+	jsonEnc := json.NewEncoder(buf)
+	jsonEnc.SetEscapeHTML(true)
+	if err := jsonEnc.Encode(enc.legacy(pd)); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), cmp.Or(enc.contentType, FormatJSON.ContentType()), nil
+}
+
+func marshalJSONBody(pd *ProblemDetails) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(true)
+	var v any = pd
+	if len(pd.Extensions) == 0 {
+		v = (*problemDetails)(pd) // Skip MarshalJSON, which would be marshaled separately then compacted by the encoder.
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// member is a member of a JSON object decoded by marshalOrdered.
+type member struct {
+	name  string
+	value any
+}
+
+// marshalOrdered returns the JSON serialization of pd decoded into generic values that keep the order of object members,
+// for serializing it in other formats with the same data model: []member for objects, []any for arrays, json.Number for numbers,
+// and string, bool or nil for the other values.
+func marshalOrdered(pd *ProblemDetails) (any, error) {
+	body, err := marshalJSONBody(pd)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	return decodeOrdered(dec)
+}
+
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		members := []member{}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member{name.(string), value})
+		}
+		_, err = dec.Token() // '}'
+		return members, err
+	case json.Delim('['):
+		items := []any{}
+		for dec.More() {
+			item, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = dec.Token() // ']'
+		return items, err
+	default:
+		return tok, nil
+	}
+}
+
+// acceptsFormat reports whether the given Accept header values allow a problem details response serialized in format, i.e. one of
+// its acceptableMediaTypes. A missing header allows it.
+func acceptsFormat(accept []string, format Format) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, mediaType := range acceptableMediaTypes(format) {
+		typ, subtype, _ := strings.Cut(mediaType, "/")
+		if mediaTypeQuality(accept, typ, subtype) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptableMediaTypes returns the media types that a problem details response serialized in format can be served as: its own media type
+// (e.g. application/problem+json), that of its structured syntax suffix (e.g. application/json), and text/xml for the +xml suffix,
+// which RFC 7303 registers as an alias of application/xml.
+func acceptableMediaTypes(format Format) []string {
+	mediaType, _, _ := strings.Cut(format.ContentType(), ";")
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	mediaTypes := []string{mediaType}
+	if _, suffix, ok := strings.Cut(subtype, "+"); ok {
+		mediaTypes = append(mediaTypes, typ+"/"+suffix)
+		if suffix == "xml" {
+			mediaTypes = append(mediaTypes, "text/xml")
+		}
+	}
+	return mediaTypes
+}
+
+// mediaTypeQuality returns the quality value that the given Accept header values give the media type typ/subtype,
+// from the most specific media range that matches it (e.g. "application/json" over "application/*" over "*/*"), or 0 if none does.
+func mediaTypeQuality(accept []string, typ string, subtype string) float64 {
+	quality, specificity := 0.0, 0
+	for _, v := range accept {
+		for mediaRange := range strings.SplitSeq(v, ",") {
+			mediaRange, params, _ := strings.Cut(mediaRange, ";")
+			rangeType, rangeSubtype, _ := strings.Cut(strings.TrimSpace(mediaRange), "/")
+
+			s := 0
+			switch {
+			case strings.EqualFold(rangeType, typ) && strings.EqualFold(rangeSubtype, subtype):
+				s = 3
+			case strings.EqualFold(rangeType, typ) && rangeSubtype == "*":
+				s = 2
+			case rangeType == "*" && rangeSubtype == "*":
+				s = 1
+			default:
+				continue
+			}
+			if s < specificity {
+				continue
+			}
+
+			q := 1.0
+			for param := range strings.SplitSeq(params, ";") {
+				name, value, _ := strings.Cut(param, "=")
+				if strings.EqualFold(strings.TrimSpace(name), "q") {
+					if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+						q = parsed
+					}
+				}
+			}
+
+			if s > specificity {
+				quality, specificity = q, s
+			} else {
+				quality = max(quality, q)
+			}
+		}
+	}
+	return quality
+}
+
+// acceptsUTF8 reports whether the given Accept-Charset header values allow a UTF-8 response.
+// A missing header, "utf-8" or "*" with a non-zero quality value (unless "utf-8" is explicitly given a zero one) allow it.
+func acceptsUTF8(acceptCharset []string) bool {
+	if len(acceptCharset) == 0 {
+		return true
+	}
+
+	wildcard := false
+	for _, v := range acceptCharset {
+		for charset := range strings.SplitSeq(v, ",") {
+			charset, params, _ := strings.Cut(charset, ";")
+			charset = strings.TrimSpace(charset)
+			if charset == "" {
+				continue
+			}
+
+			acceptable := true
+			for param := range strings.SplitSeq(params, ";") {
+				name, value, _ := strings.Cut(param, "=")
+				if strings.EqualFold(strings.TrimSpace(name), "q") {
+					q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+					acceptable = err != nil || q > 0
+				}
+			}
+
+			switch {
+			case strings.EqualFold(charset, "utf-8"):
+				return acceptable
+			case charset == "*":
+				wildcard = acceptable
+			}
+		}
+	}
+	return wildcard
+}
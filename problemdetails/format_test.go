@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	pd := &ProblemDetails{Status: http.StatusNotFound, Detail: "No such user."}
+
+	body, contentType, err := Marshal(pd, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, contentType, "application/problem+json; charset=utf-8")
+	assertEqual(t, string(body), `{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","detail":"No such user."}`+"\n")
+	assertEqual(t, pd.Type, "")
+	assertEqual(t, pd.Title, "")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	Write(w, r, http.StatusNotFound, "No such user.", "")
+	assertEqual(t, w.Body.String(), string(body))
+}
+
+func TestMarshalFormats(t *testing.T) {
+	pd := &ProblemDetails{
+		Type:       "about:blank",
+		Status:     http.StatusForbidden,
+		Title:      "Forbidden",
+		Extensions: map[string]any{"balance": -30, "accounts": []string{"/a/1", "/a/2"}, "ok": true, "ratio": 0.5, "$ref": "x"},
+	}
+
+	tests := []struct {
+		format      Format
+		contentType string
+		body        string
+	}{
+		{FormatJSON, "application/problem+json; charset=utf-8", `{"type":"about:blank","status":403,"title":"Forbidden","$ref":"x","accounts":["/a/1","/a/2"],"balance":-30,"ok":true,"ratio":0.5}` + "\n"},
+		{FormatXML, "application/problem+xml; charset=utf-8", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<problem xmlns="urn:ietf:rfc:7807"><type>about:blank</type><status>403</status><title>Forbidden</title>` +
+			`<accounts><i>/a/1</i><i>/a/2</i></accounts><balance>-30</balance><ok>true</ok><ratio>0.5</ratio></problem>` + "\n"},
+		{FormatCBOR, "application/problem+cbor", "\xa8" +
+			"\x64type\x6babout:blank" +
+			"\x66status\x19\x01\x93" +
+			"\x65title\x69Forbidden" +
+			"\x64$ref\x61x" +
+			"\x68accounts\x82\x64/a/1\x64/a/2" +
+			"\x67balance\x38\x1d" +
+			"\x62ok\xf5" +
+			"\x65ratio\xfb\x3f\xe0\x00\x00\x00\x00\x00\x00"},
+	}
+
+	for _, test := range tests {
+		body, contentType, err := Marshal(pd, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, test.format.ContentType(), test.contentType)
+		assertEqual(t, contentType, test.contentType)
+		assertEqual(t, string(body), test.body)
+	}
+
+	w := httptest.NewRecorder()
+	NewConfig(WithFormat(FormatXML)).Write(w, httptest.NewRequest("GET", "/", nil), http.StatusNotFound, "No <such> user.", "")
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+xml; charset=utf-8")
+	assertEqual(t, w.Body.String(), `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<problem xmlns="urn:ietf:rfc:7807"><type>https://problems-registry.smartbear.com/not-found</type><status>404</status>`+
+		`<title>Not Found</title><detail>No &lt;such&gt; user.</detail></problem>`+"\n")
+}
+
+func TestMarshalUnknownFormat(t *testing.T) {
+	if _, _, err := Marshal(&ProblemDetails{}, Format(-1)); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+	assertEqual(t, Format(-1).ContentType(), "")
+}
+
+func TestMarshalNil(t *testing.T) {
+	if _, _, err := Marshal(nil, FormatJSON); err == nil {
+		t.Fatal("expected an error for a nil problem details object")
+	}
+}
+
+func TestAcceptsFormat(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                               true,
+		"*/*":                            true,
+		"application/*":                  true,
+		"application/json":               true,
+		"application/problem+json;q=0.5": true,
+		"application/pdf":                false,
+		"text/html, application/xml":     false,
+		"application/pdf, */*;q=0.1":     true,
+		"*/*, application/json;q=0":      true,
+		"*/*, application/json;q=0, application/problem+json;q=0": false,
+		"application/*;q=0, application/json":                     true,
+		"application/*;q=0":                                       false,
+	} {
+		var values []string
+		if header != "" {
+			values = []string{header}
+		}
+		assertEqual(t, acceptsFormat(values, FormatJSON), want)
+	}
+
+	for header, want := range map[string]bool{
+		"application/problem+xml": true,
+		"application/xml;q=0.5":   true,
+		"text/xml":                true,
+		"text/*":                  true,
+		"application/json":        false,
+	} {
+		assertEqual(t, acceptsFormat([]string{header}, FormatXML), want)
+	}
+	assertEqual(t, acceptsFormat([]string{"application/cbor"}, FormatCBOR), true)
+	assertEqual(t, acceptsFormat([]string{"application/json"}, FormatCBOR), false)
+}
+
+func TestAcceptsUTF8(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                         true,
+		"utf-8":                    true,
+		"UTF-8;q=0.5":              true,
+		"iso-8859-1":               false,
+		"iso-8859-1, *;q=0.1":      true,
+		"iso-8859-1, *":            true,
+		"*, utf-8;q=0":             false,
+		"iso-8859-1, utf-8;q=0.0":  false,
+		"iso-8859-1;q=1, utf-8;q=": true,
+	} {
+		var values []string
+		if header != "" {
+			values = []string{header}
+		}
+		assertEqual(t, acceptsUTF8(values), want)
+	}
+}
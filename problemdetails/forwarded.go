@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestURL returns the absolute URL of the request r as seen by the client: its scheme ("https" if r.TLS is set, otherwise "http"),
+// r.Host, and the path and query of r.URL.
+//
+// If trustForwarded is true, the scheme and host are taken from the Forwarded header (RFC 7239) if it has them, and otherwise from
+// the X-Forwarded-Proto and X-Forwarded-Host headers, using the first proxy's values. Invalid values are ignored.
+// Clients can set these headers to anything, so trustForwarded must only be true behind a reverse proxy that sets or overwrites them.
+func RequestURL(r *http.Request, trustForwarded bool) *url.URL {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+
+	if trustForwarded {
+		proto, fwdHost := parseForwarded(r.Header.Get("Forwarded"))
+		if proto == "" {
+			proto = firstListValue(r.Header.Get("X-Forwarded-Proto"))
+		}
+		if fwdHost == "" {
+			fwdHost = firstListValue(r.Header.Get("X-Forwarded-Host"))
+		}
+
+		if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if validHost(fwdHost) {
+			host = fwdHost
+		}
+	}
+
+	return &url.URL{Scheme: scheme, Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+}
+
+// parseForwarded returns the proto and host parameters of the first element of the Forwarded header value v, or "" for missing ones.
+func parseForwarded(v string) (proto string, host string) {
+	element, _, _ := strings.Cut(v, ",")
+	for pair := range strings.SplitSeq(element, ";") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		value = unquote(strings.TrimSpace(value))
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "proto":
+			proto = value
+		case "host":
+			host = value
+		}
+	}
+	return proto, host
+}
+
+// unquote returns the content of the quoted-string s (RFC 9110 section 5.6.4), or s as is if it isn't quoted.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// firstListValue returns the first value of the comma-separated list v (as in X-Forwarded-* headers), trimmed.
+func firstListValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// validHost reports whether host is a non-empty host with an optional port, and nothing else (e.g. no path or userinfo).
+func validHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/?#@\\ \t") {
+		return false
+	}
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		trusted string
+	}{
+		{"none", nil, "http://example.com/orders/1?x=y"},
+		{"forwarded", map[string]string{"Forwarded": `for=192.0.2.1;proto=https;host="public.example.com:8443", for=10.0.0.1;proto=http;host=internal`}, "https://public.example.com:8443/orders/1?x=y"},
+		{"x-forwarded", map[string]string{"X-Forwarded-Proto": "HTTPS", "X-Forwarded-Host": "public.example.com, internal"}, "https://public.example.com/orders/1?x=y"},
+		{"forwarded precedence", map[string]string{"Forwarded": "host=a.example.com", "X-Forwarded-Host": "b.example.com", "X-Forwarded-Proto": "https"}, "https://a.example.com/orders/1?x=y"},
+		{"invalid", map[string]string{"X-Forwarded-Proto": "javascript", "X-Forwarded-Host": "evil.example.com/path"}, "http://example.com/orders/1?x=y"},
+		{"userinfo", map[string]string{"Forwarded": "host=user@evil.example.com"}, "http://example.com/orders/1?x=y"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://example.com/orders/1?x=y", nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+
+			assertEqual(t, RequestURL(r, false).String(), "http://example.com/orders/1?x=y")
+			assertEqual(t, RequestURL(r, true).String(), test.trusted)
+		})
+	}
+
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	assertEqual(t, RequestURL(r, false).String(), "https://example.com/")
+}
+
+func TestWriteAbsoluteInstance(t *testing.T) {
+	write := func(pdw *Writer, instance string) string {
+		r := httptest.NewRequest("GET", "http://internal:8080/orders/1", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "api.example.com")
+		w := httptest.NewRecorder()
+		pdw.WriteProblem(w, r, &ProblemDetails{Status: http.StatusNotFound, Instance: instance})
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		return pd.Instance
+	}
+
+	assertEqual(t, write(&Writer{}, "/orders/1"), "/orders/1")
+	assertEqual(t, write(&Writer{AbsoluteInstance: true}, "/orders/1"), "http://internal:8080/orders/1")
+	assertEqual(t, write(&Writer{AbsoluteInstance: true, TrustForwarded: true}, "/orders/1"), "https://api.example.com/orders/1")
+	assertEqual(t, write(&Writer{AbsoluteInstance: true, TrustForwarded: true}, "urn:uuid:123"), "urn:uuid:123")
+	assertEqual(t, write(&Writer{AbsoluteInstance: true}, ""), "")
+}
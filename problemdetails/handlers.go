@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// NotFound returns a handler that writes a HTTP 404 (Not Found) problem details response using the default problem details writer,
+// with the requested path as the instance. It's a drop-in replacement for http.NotFoundHandler.
+//
+// It can be registered as a catch-all route (e.g. `mux.Handle("/", problemdetails.NotFound())`),
+// or with chi: `r.NotFound(problemdetails.NotFound().ServeHTTP)`.
+func NotFound() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeWithInstance(w, r, http.StatusNotFound, "", r.URL.Path)
+	})
+}
+
+// MethodNotAllowed returns a handler that writes a HTTP 405 (Method Not Allowed) problem details response using the default problem
+// details writer, with the requested path as the instance.
+//
+// allowed: The methods supported by the resource, written to the Allow header. If empty, the Allow header is left as is.
+//
+// With chi: `r.MethodNotAllowed(problemdetails.MethodNotAllowed("GET", "POST").ServeHTTP)`.
+func MethodNotAllowed(allowed ...string) http.Handler {
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allow != "" {
+			w.Header().Set("Allow", allow)
+		}
+		writeWithInstance(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("The method %s is not allowed for this resource.", r.Method), r.URL.Path)
+	})
+}
+
+func writeWithInstance(w http.ResponseWriter, r *http.Request, status int, detail string, instance string) {
+	pdw := Default()
+	pdCtx := FromContext(r.Context())
+	pd := pdw.newProblemDetails(r, pdCtx, status, detail, "", nil)
+	pd.Instance = instance
+	pdw.writeProblemDetails(w, r, pdCtx, pd, encoding{format: FormatJSON})
+}
+
+// JSONHandler returns a handler that calls fn and writes its result as JSON, or its error as a problem details response (see WriteError),
+// so a *ProblemDetails returned by fn is written as is.
+//
+// The status code of successful responses is http.StatusOK, unless set otherwise with WithSuccessStatus. Problem details responses are written
+// using the writer set with WithWriter. Other options are ignored.
+func JSONHandler[T any](fn func(*http.Request) (T, error), opts ...Option) http.Handler {
+	c := NewConfig(opts...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, err := fn(r)
+		if err != nil {
+			c.WriteError(w, r, err)
+			return
+		}
+
+		body, err := json.Marshal(v)
+		if err != nil {
+			c.WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(c.SuccessStatus)
+		w.Write(append(body, '\n'))
+	})
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/", NotFound())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/foo/bar", nil))
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Status, http.StatusNotFound)
+	assertEqual(t, pd.Instance, "/foo/bar")
+}
+
+func TestChiNotFoundAndMethodNotAllowed(t *testing.T) {
+	r := chi.NewRouter()
+	r.NotFound(NotFound().ServeHTTP)
+	r.MethodNotAllowed(MethodNotAllowed("GET", "POST").ServeHTTP)
+	r.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+	r.Post("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, resBody := testRequest(t, ts, "GET", "/foo", nil)
+	assertEqual(t, res.StatusCode, http.StatusNotFound)
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal([]byte(resBody), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Instance, "/foo")
+
+	res, resBody = testRequest(t, ts, "DELETE", "/users", nil)
+	assertEqual(t, res.StatusCode, http.StatusMethodNotAllowed)
+	assertEqual(t, res.Header.Get("Allow"), "GET, POST")
+
+	pd = &ProblemDetails{}
+	if err := json.Unmarshal([]byte(resBody), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Status, http.StatusMethodNotAllowed)
+	assertEqual(t, pd.Detail, "The method DELETE is not allowed for this resource.")
+	assertEqual(t, pd.Instance, "/users")
+}
+
+type user struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestJSONHandler(t *testing.T) {
+	errDatabase := errors.New("pq: connection refused")
+
+	r := chi.NewRouter()
+	r.Use(ProblemDetailsContext)
+	r.Method("GET", "/users/1", JSONHandler(func(r *http.Request) (user, error) {
+		return user{ID: 1, Name: "foo"}, nil
+	}))
+	r.Method("POST", "/users", JSONHandler(func(r *http.Request) (*user, error) {
+		return &user{ID: 2, Name: "bar"}, nil
+	}, WithSuccessStatus(http.StatusCreated)))
+	r.Method("GET", "/users/2", JSONHandler(func(r *http.Request) (*user, error) {
+		return nil, fmt.Errorf("getting user: %w", &ProblemDetails{Status: http.StatusNotFound, Detail: "No such user."})
+	}))
+	var pdCtx *Context
+	r.Method("GET", "/users/3", JSONHandler(func(r *http.Request) (*user, error) {
+		pdCtx = r.Context().Value(CtxKey).(*Context)
+		return nil, errDatabase
+	}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil))
+
+	assertEqual(t, w.Code, http.StatusOK)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/json")
+	assertEqual(t, w.Body.String(), `{"id":1,"name":"foo"}`+"\n")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/users", nil))
+
+	assertEqual(t, w.Code, http.StatusCreated)
+	assertEqual(t, w.Body.String(), `{"id":2,"name":"bar"}`+"\n")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/2", nil))
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","detail":"No such user."}`+"\n")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/3", nil))
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/server-error","status":500,"title":"Internal Server Error"}`+"\n")
+	if !errors.Is(pdCtx.Details(), errDatabase) {
+		t.Fatal("expected the error to be the cause of the written problem details")
+	}
+}
+
+func TestJSONHandlerMarshalError(t *testing.T) {
+	h := JSONHandler(func(r *http.Request) (func(), error) { return func() {}, nil })
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+}
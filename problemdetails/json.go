@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// problemDetails has the same fields as ProblemDetails but none of its methods, so it can be marshaled without recursing into MarshalJSON.
+type problemDetails ProblemDetails
+
+// Names of the members serialized from the fields of ProblemDetails, which extension members can't override.
+var reservedMembers = map[string]struct{}{
+	"$schema":        {},
+	"type":           {},
+	"status":         {},
+	"title":          {},
+	"detail":         {},
+	"instance":       {},
+	"requestId":      {},
+	"traceId":        {},
+	"code":           {},
+	"errors":         {},
+	"links":          {},
+	"invalid-params": {},
+}
+
+// MarshalJSON implements json.Marshaler, flattening the Extensions into top-level members of the problem details object.
+// time.Time extension values are serialized as RFC 3339 strings, and time.Duration values according to the DurationFormat (see WithDurationFormat).
+// If there are no extensions the struct is marshaled directly, without building any intermediate representation.
+//
+// An empty Type is serialized as "about:blank", which is what an absent type means per RFC 9457, so the result is always a valid
+// problem details object. A nil *ProblemDetails is serialized as null by json.Marshal.
+func (pd ProblemDetails) MarshalJSON() ([]byte, error) {
+	if pd.Type == "" {
+		pd.Type = "about:blank"
+	}
+
+	b, err := json.Marshal((*problemDetails)(&pd))
+	if err != nil || len(pd.Extensions) == 0 {
+		return b, err
+	}
+
+	buf := bytes.NewBuffer(b[:len(b)-1]) // Drop the closing brace.
+	for _, name := range slices.Sorted(maps.Keys(pd.Extensions)) {
+		if _, ok := reservedMembers[name]; ok {
+			continue
+		}
+
+		value, err := json.Marshal(pd.extensionValue(pd.Extensions[name]))
+		if err != nil {
+			return nil, fmt.Errorf("problemdetails: marshaling extension member %q: %w", name, err)
+		}
+		key, _ := json.Marshal(name)
+
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, collecting members that don't correspond to a field of ProblemDetails into Extensions.
+// Extension values are decoded as by json.Unmarshal into an `any`, so e.g. timestamps and durations are decoded as strings or float64s.
+//
+// For interoperability with loosely-typed producers, the status may be either a JSON number or a string containing one, e.g. "404".
+func (pd *ProblemDetails) UnmarshalJSON(b []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil {
+		return err
+	}
+
+	fields := struct {
+		*problemDetails
+		Status json.RawMessage `json:"status"` // Shadows problemDetails.Status.
+	}{problemDetails: (*problemDetails)(pd)}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields.Status) > 0 {
+		status, err := parseStatus(fields.Status)
+		if err != nil {
+			return err
+		}
+		pd.Status = status
+	}
+
+	for name, raw := range members {
+		if _, ok := reservedMembers[name]; ok {
+			continue
+		}
+
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("problemdetails: unmarshaling extension member %q: %w", name, err)
+		}
+		if pd.Extensions == nil {
+			pd.Extensions = make(map[string]any, len(members))
+		}
+		pd.Extensions[name] = value
+	}
+
+	return nil
+}
+
+// parseStatus parses a status member that's either a JSON number or a JSON string containing an integer. null parses as 0.
+func parseStatus(raw json.RawMessage) (int, error) {
+	var status int
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, fmt.Errorf("problemdetails: invalid status %s: %w", raw, err)
+		}
+		status, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("problemdetails: invalid status %s: not an integer", raw)
+		}
+		return status, nil
+	}
+
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return 0, fmt.Errorf("problemdetails: invalid status %s: not an integer", raw)
+	}
+	return status, nil
+}
+
+// DurationFormat is the format in which time.Duration extension values are serialized.
+type DurationFormat int
+
+const (
+	DurationSeconds DurationFormat = iota // A JSON number of seconds, e.g. 1.5. This is the default.
+	DurationISO8601                       // An ISO 8601 duration string, e.g. "PT1M30S".
+)
+
+// WithDurationFormat sets the format in which time.Duration extension values of pd are serialized and returns pd.
+func (pd *ProblemDetails) WithDurationFormat(format DurationFormat) *ProblemDetails {
+	pd.durationFormat = format
+	return pd
+}
+
+func (pd *ProblemDetails) extensionValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		if pd.durationFormat == DurationISO8601 {
+			return formatISO8601Duration(v)
+		}
+		return v.Seconds()
+	}
+	return v
+}
+
+// formatISO8601Duration formats d as an ISO 8601 duration using only the hour, minute and second designators, e.g. "PT1H1.5S".
+func formatISO8601Duration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+
+	var sb strings.Builder
+	u := uint64(d)
+	if d < 0 {
+		sb.WriteByte('-')
+		u = -u
+	}
+	sb.WriteString("PT")
+
+	if h := u / uint64(time.Hour); h > 0 {
+		sb.WriteString(strconv.FormatUint(h, 10))
+		sb.WriteByte('H')
+		u -= h * uint64(time.Hour)
+	}
+	if m := u / uint64(time.Minute); m > 0 {
+		sb.WriteString(strconv.FormatUint(m, 10))
+		sb.WriteByte('M')
+		u -= m * uint64(time.Minute)
+	}
+	if u > 0 {
+		sb.WriteString(strconv.FormatFloat(float64(u)/float64(time.Second), 'f', -1, 64))
+		sb.WriteByte('S')
+	}
+
+	return sb.String()
+}
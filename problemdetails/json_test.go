@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSONExtensions(t *testing.T) {
+	pd := &ProblemDetails{
+		Type:   "about:blank",
+		Status: http.StatusForbidden,
+		Title:  "Forbidden",
+		Extensions: map[string]any{
+			"balance":  30,
+			"accounts": []string{"/account/12345", "/account/67890"},
+			"status":   500,
+			"title":    "ignored",
+		},
+	}
+
+	b, err := json.Marshal(pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), `{"type":"about:blank","status":403,"title":"Forbidden","accounts":["/account/12345","/account/67890"],"balance":30}`)
+
+	pd.Extensions = map[string]any{"bad": func() {}}
+	if _, err := json.Marshal(pd); err == nil {
+		t.Fatal("expected an error for an unsupported extension value")
+	}
+}
+
+func TestMarshalJSONNilAndZero(t *testing.T) {
+	b, err := json.Marshal((*ProblemDetails)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), "null")
+
+	b, err = json.Marshal(ProblemDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), `{"type":"about:blank","status":0,"title":""}`)
+
+	b, err = json.Marshal(&ProblemDetails{Extensions: map[string]any{"a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), `{"type":"about:blank","status":0,"title":"","a":1}`)
+}
+
+func TestMarshalJSONTimeExtensions(t *testing.T) {
+	timestamp := time.Date(2025, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	pd := &ProblemDetails{
+		Type:   "about:blank",
+		Status: http.StatusTooManyRequests,
+		Title:  "Too Many Requests",
+		Extensions: map[string]any{
+			"timestamp":  timestamp,
+			"retryAfter": 90*time.Second + 500*time.Millisecond,
+		},
+	}
+
+	b, err := json.Marshal(pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), `{"type":"about:blank","status":429,"title":"Too Many Requests","retryAfter":90.5,"timestamp":"2025-01-02T03:04:05.6Z"}`)
+
+	got := &ProblemDetails{}
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got.Status, http.StatusTooManyRequests)
+	assertEqual(t, got.Extensions["retryAfter"], 90.5)
+	parsed, err := time.Parse(time.RFC3339, got.Extensions["timestamp"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, parsed.Equal(timestamp), true)
+
+	b, err = json.Marshal(pd.WithDurationFormat(DurationISO8601))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = &ProblemDetails{}
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got.Extensions["retryAfter"], "PT1M30.5S")
+}
+
+func TestFormatISO8601Duration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                                   "PT0S",
+		time.Second:                         "PT1S",
+		1500 * time.Millisecond:             "PT1.5S",
+		time.Hour + time.Second:             "PT1H1S",
+		25*time.Hour + 30*time.Minute:       "PT25H30M",
+		-(2*time.Minute + time.Millisecond): "-PT2M0.001S",
+	} {
+		assertEqual(t, formatISO8601Duration(d), want)
+	}
+}
+
+func TestUnmarshalJSONExtensions(t *testing.T) {
+	pd := &ProblemDetails{}
+	err := json.Unmarshal([]byte(`{"type":"about:blank","status":403,"title":"Forbidden","balance":30,"accounts":["/account/1"]}`), pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Status, http.StatusForbidden)
+	assertEqual(t, pd.Extensions, map[string]any{"balance": 30.0, "accounts": []any{"/account/1"}})
+}
+
+func TestUnmarshalJSONStatus(t *testing.T) {
+	for body, want := range map[string]int{
+		`{"status":404}`:   404,
+		`{"status":"404"}`: 404,
+		`{"status":null}`:  0,
+		`{}`:               0,
+	} {
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal([]byte(body), pd); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		assertEqual(t, pd.Status, want)
+	}
+
+	for _, body := range []string{
+		`{"status":"not found"}`,
+		`{"status":"404.5"}`,
+		`{"status":404.5}`,
+		`{"status":true}`,
+		`{"status":{}}`,
+	} {
+		pd := &ProblemDetails{}
+		err := json.Unmarshal([]byte(body), pd)
+		if err == nil {
+			t.Fatalf("%s: expected an error", body)
+		}
+		if !strings.HasPrefix(err.Error(), "problemdetails: invalid status ") {
+			t.Fatalf("%s: unexpected error: %v", body, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+)
+
+var (
+	logExcludedExtensionsMu sync.RWMutex
+	logExcludedExtensions   = map[string]struct{}{}
+)
+
+// ExcludeLogExtensions excludes the extension members with the given names from the log representation of all problem details objects
+// (see ProblemDetails.LogValue), e.g. because they contain sensitive information. They're still written to responses.
+//
+// It's safe to call concurrently, but is meant to be called during initialization.
+func ExcludeLogExtensions(names ...string) {
+	logExcludedExtensionsMu.Lock()
+	defer logExcludedExtensionsMu.Unlock()
+	for _, name := range names {
+		logExcludedExtensions[name] = struct{}{}
+	}
+}
+
+// LogValue implements slog.LogValuer, so that logging a *ProblemDetails produces a group of its type, title, status, and (if they're set)
+// detail, instance, and code, followed by its extension members sorted by name, except the ones excluded with ExcludeLogExtensions.
+func (pd *ProblemDetails) LogValue() slog.Value {
+	if pd == nil {
+		return slog.AnyValue(nil)
+	}
+
+	attrs := make([]slog.Attr, 0, 6+len(pd.Extensions))
+	attrs = append(attrs,
+		slog.String("type", pd.Type),
+		slog.String("title", pd.Title),
+		slog.Int("status", pd.Status),
+	)
+	if pd.Detail != "" {
+		attrs = append(attrs, slog.String("detail", pd.Detail))
+	}
+	if pd.Instance != "" {
+		attrs = append(attrs, slog.String("instance", pd.Instance))
+	}
+	if pd.Code != "" {
+		attrs = append(attrs, slog.String("code", pd.Code))
+	}
+
+	if len(pd.Extensions) > 0 {
+		logExcludedExtensionsMu.RLock()
+		defer logExcludedExtensionsMu.RUnlock()
+		for _, name := range slices.Sorted(maps.Keys(pd.Extensions)) {
+			if _, ok := reservedMembers[name]; ok {
+				continue
+			}
+			if _, ok := logExcludedExtensions[name]; ok {
+				continue
+			}
+			attrs = append(attrs, slog.Any(name, pd.Extensions[name]))
+		}
+	}
+
+	return slog.GroupValue(attrs...)
+}
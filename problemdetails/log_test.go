@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProblemDetailsLogValue(t *testing.T) {
+	ExcludeLogExtensions("token")
+	defer func() {
+		logExcludedExtensionsMu.Lock()
+		clear(logExcludedExtensions)
+		logExcludedExtensionsMu.Unlock()
+	}()
+
+	pd := &ProblemDetails{
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     http.StatusForbidden,
+		Title:      "You do not have enough credit.",
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Extensions: map[string]any{"balance": 30, "token": "secret", "status": 500},
+	}
+
+	value := pd.LogValue()
+	assertEqual(t, value.Kind(), slog.KindGroup)
+	assertEqual(t, value.Group(), []slog.Attr{
+		slog.String("type", "https://example.com/probs/out-of-credit"),
+		slog.String("title", "You do not have enough credit."),
+		slog.Int("status", http.StatusForbidden),
+		slog.String("detail", "Your current balance is 30, but that costs 50."),
+		slog.Int("balance", 30),
+	})
+
+	logs := &strings.Builder{}
+	slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})).Error("request failed", "problem", (&ProblemDetails{Status: http.StatusNotFound, Title: "Not Found"}).WithCode("USER_NOT_FOUND"))
+
+	assertEqual(t, logs.String(), `level=ERROR msg="request failed" problem.type="" problem.title="Not Found" problem.status=404 problem.code=USER_NOT_FOUND`+"\n")
+}
@@ -4,12 +4,22 @@
 package problemdetails
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Recoverer is a middleware that recovers from panics and returns a HTTP 500 (Internal Server Error) problem details response, if possible.
@@ -18,10 +28,35 @@ import (
 // stackFrameIdx: The index of the caller in the stack frame to include in the details field in the response body.
 // If < 0 then it wond be included. Note that the actual index used is actually stackFrameIdx + 3 in order to skip the frames for this middleware and runtime/panic.go.
 //
+// If the response was already committed (its header or part of its body was written, or the connection was hijacked, e.g. upgraded to a WebSocket)
+// when the panic occurred, writing a problem details response would corrupt it, so instead the panic is logged using slog.Default() and the response
+// is left as is. Requests that ask for an upgrade (with the Connection: Upgrade header) get a problem details response as usual if the handler panics
+// before upgrading the connection.
+//
 // The recoverer should be registered as early as possible.
 func Recoverer(stackFrameIdx int) func(http.Handler) http.Handler {
+	return NewConfig(WithStackFrames(stackFrameIdx)).Recoverer()
+}
+
+// Recoverer is like the top-level function Recoverer, but uses c.StackFrameIdx, c.PanicType and c.PanicTitle, and writes the problem details
+// responses using c.
+// Panics after the response was committed are logged using c.Logger.
+// If c.PanicBodyBytes > 0 and c.RedactPanicBody isn't nil, a snippet of the request body is captured for panics (see WithPanicBody).
+// Otherwise the body is left untouched.
+func (c *Config) Recoverer() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &recovererWriter{ResponseWriter: w}
+
+			var body *bodySnippet
+			if c.PanicBodyBytes > 0 && c.RedactPanicBody != nil && r.Body != nil && r.Body != http.NoBody {
+				body = &bodySnippet{max: c.PanicBodyBytes}
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(r.Body, body), r.Body}
+			}
+
 			// Based on original work from https://github.com/go-chi/chi/blob/9b9fb55def404397748a9fc7e044efe9db1d618e/middleware/recoverer.go
 			// Licensed under the MIT License: https://github.com/go-chi/chi/blob/9b9fb55def404397748a9fc7e044efe9db1d618e/LICENSE
 			// Copyright (c) 2015-present Peter Kieltyka (https://github.com/pkieltyka), Google Inc.
@@ -32,33 +67,121 @@ func Recoverer(stackFrameIdx int) func(http.Handler) http.Handler {
 						panic(rec)
 					}
 
-					if r.Header.Get("Connection") == "Upgrade" {
-						return
-					}
+					pd := c.problemFromPanic(rec, 0)
 
-					var detail string
-					if stackFrameIdx >= 0 {
-						var buf [1]uintptr
-						pc := buf[:]
-						n := runtime.Callers(stackFrameIdx+3, pc) // Skip 3 frames for this middleware + runtime/panic.go.
-						if n == 1 {
-							frame, _ := runtime.CallersFrames(pc).Next()
-							detail = fmt.Sprintf("panic: '%v' at %s:%d", rec, frame.File, frame.Line)
+					logArgs := []any{"detail", pd.Detail}
+					if body != nil {
+						snippet := c.RedactPanicBody(body.buf)
+						if pdCtx := c.FromContext(r.Context()); pdCtx != nil {
+							pdCtx.panicBody = snippet
 						}
+						logArgs = append(logArgs, "body", snippet)
 					}
-					if detail == "" {
-						detail = fmt.Sprintf("panic: '%v'", rec)
+
+					if rw.committed {
+						c.logger().ErrorContext(r.Context(), "problemdetails: recovered from panic after the response was committed", logArgs...)
+						return
 					}
 
-					Write(w, r, http.StatusInternalServerError, detail, "")
+					c.WriteProblem(w, r, pd)
 				}
 			}()
 
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(rw, r)
 		})
 	}
 }
 
+// ProblemFromPanic returns the HTTP 500 (Internal Server Error) problem details object that Recoverer would write for the recovered value rec,
+// for recover loops outside of HTTP handlers (e.g. in background workers). It uses the stack frame index, panic type URI and panic title
+// set with opts (see WithStackFrames and WithPanicType), which are applied to the default config.
+//
+// It must be called directly by the deferred function that called recover, so that the stack frame index refers to the same frames as with Recoverer:
+//
+//	defer func() {
+//		if rec := recover(); rec != nil {
+//			pd := problemdetails.ProblemFromPanic(rec, problemdetails.WithStackFrames(0))
+//			...
+//		}
+//	}()
+func ProblemFromPanic(rec any, opts ...Option) *ProblemDetails {
+	return NewConfig(opts...).problemFromPanic(rec, 1)
+}
+
+// problemFromPanic returns the problem details object for the recovered value rec, using c.StackFrameIdx, c.PanicType and c.PanicTitle.
+// It must be called by the deferred function that called recover, with skip being the number of frames between them.
+func (c *Config) problemFromPanic(rec any, skip int) *ProblemDetails {
+	var detail string
+	if c.StackFrameIdx >= 0 {
+		var buf [1]uintptr
+		pc := buf[:]
+		// Skip runtime.Callers, this function, the skipped frames, the deferred function, and runtime/panic.go.
+		n := runtime.Callers(c.StackFrameIdx+skip+4, pc)
+		if n == 1 {
+			frame, _ := runtime.CallersFrames(pc).Next()
+			detail = fmt.Sprintf("panic: '%v' at %s:%d", rec, frame.File, frame.Line)
+		}
+	}
+	if detail == "" {
+		detail = fmt.Sprintf("panic: '%v'", rec)
+	}
+
+	return &ProblemDetails{
+		Type:   c.PanicType,
+		Status: http.StatusInternalServerError,
+		Title:  c.PanicTitle,
+		Detail: detail,
+	}
+}
+
+// bodySnippet is an io.Writer that keeps the first max bytes written to it, and discards the rest.
+type bodySnippet struct {
+	buf []byte
+	max int
+}
+
+func (b *bodySnippet) Write(p []byte) (int, error) {
+	n := min(len(p), b.max-len(b.buf))
+	b.buf = append(b.buf, p[:n]...)
+	return len(p), nil
+}
+
+// recovererWriter tracks whether the response has been committed.
+type recovererWriter struct {
+	http.ResponseWriter
+	committed bool
+}
+
+func (rw *recovererWriter) WriteHeader(status int) {
+	if status >= 200 || status == http.StatusSwitchingProtocols { // Other informational responses can be followed by the final one.
+		rw.committed = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recovererWriter) Write(body []byte) (int, error) {
+	rw.committed = true
+	return rw.ResponseWriter.Write(body)
+}
+
+func (rw *recovererWriter) Flush() {
+	rw.committed = true
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *recovererWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.committed = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController.
+func (rw *recovererWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 type ctxKey string
 
 // Value: `*problemdetails.Context`
@@ -67,33 +190,183 @@ var CtxKey = ctxKey("problemdetails")
 type Context struct {
 	pd           *ProblemDetails
 	respWriteErr error
+
+	instance  string
+	typeUri   string
+	panicBody string
+}
+
+// FromContext returns the `*problemdetails.Context` in ctx with key CtxKey (see ProblemDetailsContext), or nil if there is none.
+// For contexts injected with a custom key, use Config.FromContext.
+func FromContext(ctx context.Context) *Context {
+	return fromContext(ctx, CtxKey)
+}
+
+func fromContext(ctx context.Context, key any) *Context {
+	c, _ := ctx.Value(key).(*Context)
+	return c
+}
+
+// SetInstance sets the instance (a URI reference that identifies the specific occurrence of the problem) of every problem details
+// response written for the request with the context ctx, e.g. the canonical route of the resource being acted on.
+// It is a no-op if ctx does not contain a `*problemdetails.Context` (see ProblemDetailsContext).
+func SetInstance(ctx context.Context, instance string) {
+	FromContext(ctx).SetInstance(instance)
+}
+
+// SetType sets the type URI of every problem details response written for the request with the context ctx,
+// overriding the type that would otherwise be derived from the status code. Pass "" to restore the default.
+// It is a no-op if ctx does not contain a `*problemdetails.Context` (see ProblemDetailsContext).
+func SetType(ctx context.Context, typeUri string) {
+	FromContext(ctx).SetType(typeUri)
+}
+
+// SetProblem records pd as the problem details object of the response to the request with the context ctx, so Context.Details returns it,
+// for handlers that write the response themselves instead of with the write functions, e.g. in a non-standard way. It doesn't write anything.
+// It is a no-op if ctx does not contain a `*problemdetails.Context` (see ProblemDetailsContext).
+func SetProblem(ctx context.Context, pd *ProblemDetails) {
+	FromContext(ctx).SetProblem(pd)
+}
+
+// SetInstance is like the top-level function SetInstance, for the request of c. It is a no-op if c is nil.
+func (c *Context) SetInstance(instance string) {
+	if c != nil {
+		c.instance = instance
+	}
+}
+
+// SetType is like the top-level function SetType, for the request of c. It is a no-op if c is nil.
+func (c *Context) SetType(typeUri string) {
+	if c != nil {
+		c.typeUri = typeUri
+	}
+}
+
+// SetProblem is like the top-level function SetProblem, for the request of c. It is a no-op if c is nil.
+// The write error (see RespWriteError) is reset, since pd wasn't written by the write functions.
+func (c *Context) SetProblem(pd *ProblemDetails) {
+	if c != nil {
+		c.pd = pd
+		c.respWriteErr = nil
+	}
 }
 
 // Details returns the problem details object written to the current response body if one was written, otherwise nil.
 // If an error occured while writing the problem details response, this method still returns the problem details object that was attempted to be written.
 // Check RespWriteError to see if it was written successfully.
+// If the detail was omitted from the response body (see Writer.RedactServerErrors), the returned object still contains it.
 func (c *Context) Details() *ProblemDetails {
 	return c.pd
 }
 
+// PanicBody returns the redacted snippet of the request body that was captured when the handler panicked (see WithPanicBody),
+// or "" if none was captured.
+func (c *Context) PanicBody() string {
+	return c.panicBody
+}
+
 // RespWriteError returns the error that occured when writing the problem details response if one occured, otherwise nil.
 func (c *Context) RespWriteError() error {
 	return c.respWriteErr
 }
 
 // ProblemDetailsContext is a middleware that injects a `*problemdetails.Context` object with key `problemdetails.CtxKey` into
-// the context of each request.
+// the context of each request. Retrieve it with FromContext.
 //
 // The `*problemdetails.Context` is meant to be used *only after* the request handler has run (for e.g. request logging).
 // The `Details()` method on it will return a `*ProblemDetails` to the same object that was written to the response body,
 // if `problemdetails.Write` was called, otherwise `nil`.
 func ProblemDetailsContext(next http.Handler) http.Handler {
+	return DefaultConfig().ProblemDetailsContext(next)
+}
+
+// ProblemDetailsContext is like the top-level function ProblemDetailsContext, but injects the `*problemdetails.Context` with key c.ContextKey.
+// Only the writes through c (including its middlewares) record problem details in it, and it can be retrieved with c.FromContext.
+func (c *Config) ProblemDetailsContext(next http.Handler) http.Handler {
+	key := c.contextKey()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.WithValue(r.Context(), CtxKey, &Context{})
+		ctx := context.WithValue(r.Context(), key, &Context{})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// FromContext returns the `*problemdetails.Context` in ctx with key c.ContextKey, or nil if there is none.
+func (c *Config) FromContext(ctx context.Context) *Context {
+	return fromContext(ctx, c.contextKey())
+}
+
+var typeBaseCtxKey = ctxKey("problemdetails.typebase")
+
+// MountWithTypeBase returns a handler that serves h, resolving the relative type URIs (e.g. "out-of-credit") of the problem details
+// responses written within it against base, e.g. to give each sub-app of a modular monolith its own type URI namespace.
+//
+// The base of the innermost mount takes precedence. If base is itself relative, it's resolved against the base of the enclosing mount,
+// or if there is none, against Writer.BaseURI (which is otherwise overridden by the base of the mount).
+// Problems written by middlewares outside the mount (e.g. a ProblemDetailsConverter registered before it) don't use its base.
+func MountWithTypeBase(base string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mountBase := base
+		if outer, ok := r.Context().Value(typeBaseCtxKey).(string); ok {
+			mountBase = joinTypeBase(outer, base)
+		}
+		ctx := context.WithValue(r.Context(), typeBaseCtxKey, mountBase)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// joinTypeBase resolves the mount base base against the base outer of the enclosing mount, which unlike with resolveTypeUri
+// may itself be relative (e.g. "a/" and "b/" are joined into "a/b/"), so the result is only resolved against Writer.BaseURI when writing.
+// If either of them is invalid, base is returned as is.
+func joinTypeBase(outer string, base string) string {
+	ref, err := url.Parse(base)
+	if err != nil || ref.IsAbs() {
+		return base
+	}
+	outerUrl, err := url.Parse(outer)
+	if err != nil {
+		return base
+	}
+
+	if !outerUrl.IsAbs() && outerUrl.Host == "" && !strings.HasPrefix(outerUrl.Path, "/") && ref.Host == "" && !strings.HasPrefix(ref.Path, "/") {
+		// ResolveReference always returns a rooted path, so resolve against the rooted outer path and make the result relative again.
+		outerUrl.Path = "/" + outerUrl.Path
+		return strings.TrimPrefix(outerUrl.ResolveReference(ref).String(), "/")
+	}
+	return outerUrl.ResolveReference(ref).String()
+}
+
+// ProblemHook is a function that can mutate a problem details object right before it's serialized. See WithProblemHook.
+type ProblemHook func(r *http.Request, pd *ProblemDetails)
+
+var problemHooksCtxKey = ctxKey("problemdetails.hooks")
+
+// WithProblemHook returns a middleware that registers hook in the context of each request. The hooks registered for a request are
+// called by every write (Write, WriteProblem, WriteError, etc., as well as ProblemDetailsConverter and Recoverer) right before
+// the problem details object is serialized, in the order they were registered (i.e. outer middlewares' hooks run first).
+//
+// Hooks run after defaults (including BaseURI and default extensions) are applied, and see the request passed to the write;
+// for ProblemDetailsConverter and Recoverer that's the request they received, so they must be registered after this middleware.
+// Hooks are on the error path of every request, so they must be fast.
+func WithProblemHook(hook ProblemHook) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hooks, _ := r.Context().Value(problemHooksCtxKey).([]ProblemHook)
+			// Clip so that appending never writes into a slice shared with another request.
+			hooks = append(slices.Clip(hooks), hook)
+			ctx := context.WithValue(r.Context(), problemHooksCtxKey, hooks)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// runProblemHooks calls the hooks registered in the context of r with pd.
+func runProblemHooks(r *http.Request, pd *ProblemDetails) {
+	hooks, _ := r.Context().Value(problemHooksCtxKey).([]ProblemHook)
+	for _, hook := range hooks {
+		hook(r, pd)
+	}
+}
+
 // ProblemDetailsConverter returns a middleware that intercepts HTTP responses with status codes >= 400
 // and converts them to RFC 9457 compliant problem detail responses if they are not already
 // (by checking if the Content-Type starts with "application/problem+json").
@@ -104,39 +377,168 @@ func ProblemDetailsContext(next http.Handler) http.Handler {
 // the later it runs.
 // It must be registered as early as possible, after middlewares that inject context like request IDs, and before any other
 // middleware that also runs after serving, including request loggers.
+//
+// Responses with statuses that must not have a body (204 No Content, 205 Reset Content and 304 Not Modified) are never converted,
+// regardless of the conversion threshold.
+//
+// The converter guarantees the following about the headers:
+//   - Converted responses never carry the trailers (declared or set with http.TrailerPrefix) of the original response,
+//     or the headers in Config.StripHeaders (by default Content-Encoding, Vary, and Content-Length), since they describe the original body.
+//     They carry the Cache-Control of the original response if it was set, otherwise the one of the writer (see Writer.CacheControl).
+//   - Responses with a status that must not have a body, and for which only WriteHeader was called, never carry Content-Length or Transfer-Encoding.
+//     Their other headers (including Content-Type) are left untouched.
+//   - All other responses are passed through untouched, including their trailers.
+//   - The header in Config.SkipConversionHeader (by default X-Skip-Problem-Conversion) is never sent to the client.
+//
+// Handlers can opt an error response out of conversion (e.g. an OAuth error with its own schema) by setting the header in
+// Config.SkipConversionHeader to any non-empty value, e.g. "X-Skip-Problem-Conversion: 1", before writing the response.
+//
+// Note that if a handler writes a body without calling WriteHeader first, the status is implied to be 200 as with any http.ResponseWriter,
+// so the response is passed through even if the handler meant it to be an error response. To still recognize the intended status
+// (e.g. from a status the handler stored in the request context), use WithImpliedStatus.
+//
+// When used with a compression middleware, register the compression middleware first (so the converter runs inside it),
+// so that converted responses are compressed too. If the converter is registered first instead, it only ever sees compressed bytes,
+// and if the compression middleware writes any for an error response, that response won't be converted.
 func ProblemDetailsConverter(callback func(r *http.Request, status int)) func(http.Handler) http.Handler {
+	return DefaultConfig().ProblemDetailsConverter(callback)
+}
+
+// ProblemDetailsConverter is like the top-level function ProblemDetailsConverter, but writes the problem details responses using c.
+func (c *Config) ProblemDetailsConverter(callback func(r *http.Request, status int)) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ri := interceptorPool.Get().(*responseInterceptor)
 			ri.ResponseWriter = w
 			ri.status = 0 // 0 indicates WriteHeader has not been called.
 			ri.bodyWritten = false
+			ri.impliedStatus = c.ImpliedStatus
+			ri.r = r
+			ri.captureLimit = c.OriginalBody
+			ri.captured = ri.captured[:0]
+			ri.skipHeader = c.SkipConversionHeader
 			defer interceptorPool.Put(ri)
 
+			var start time.Time
+			if c.OnConvert != nil {
+				start = time.Now()
+			}
+
 			next.ServeHTTP(ri, r)
 
+			var duration time.Duration
+			if c.OnConvert != nil {
+				duration = time.Since(start)
+			}
+
+			if ri.status == 0 && !ri.bodyWritten && c.ImpliedStatus != nil {
+				ri.status = c.ImpliedStatus(r, w.Header())
+			}
+
+			skipped := ri.skipped()
 			ri.ResponseWriter = nil
+			ri.impliedStatus = nil
+			ri.r = nil
 
-			if ri.status >= 400 && !ri.bodyWritten && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
-				w.Header().Del("Content-Encoding")
-				w.Header().Del("Vary")
-				w.Header().Del("Content-Length")
+			if ShouldConvert(ri.status) && !ri.bodyWritten && !skipped && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
+				contentType := w.Header().Get("Content-Type")
+				c.stripHeaders(w.Header())
+				delTrailers(w.Header())
 
-				Write(w, r, ri.status, "", "")
+				if len(ri.captured) > 0 {
+					c.WriteProblem(w, r, originalBodyProblem(ri.status, contentType, ri.captured))
+				} else {
+					c.Write(w, r, ri.status, "", "")
+				}
 
 				callback(r, ri.status)
+				if c.OnConvert != nil {
+					c.OnConvert(r, ri.status, true, duration)
+				}
 				return
 			}
 
+			if !ri.bodyWritten && c.SkipConversionHeader != "" {
+				w.Header().Del(c.SkipConversionHeader)
+			}
+
 			// If we didn't convert the response, ensure the status header is written
 			// in cases where only WriteHeader was called, like with 204.
 			if !ri.bodyWritten && ri.status != 0 {
+				if !bodyAllowed(ri.status) {
+					// A previous middleware might have set these, but there is no body.
+					w.Header().Del("Content-Length")
+					w.Header().Del("Transfer-Encoding")
+				}
 				w.WriteHeader(ri.status)
+				if len(ri.captured) > 0 {
+					_, _ = w.Write(ri.captured)
+				}
 			}
+
+			if c.OnConvert != nil {
+				c.OnConvert(r, cmp.Or(ri.status, http.StatusOK), false, duration)
+			}
+		})
+	}
+}
+
+// originalBodyProblem returns the problem details object for a converted error response with the given status whose handler wrote body.
+func originalBodyProblem(status int, contentType string, body []byte) *ProblemDetails {
+	pd := &ProblemDetails{Status: status}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && json.Valid(body) {
+		pd.Extensions = map[string]any{"original": json.RawMessage(bytes.Clone(body))}
+	} else {
+		pd.Detail = strings.TrimSpace(string(body))
+	}
+	return pd
+}
+
+// stripHeaders deletes the headers in c.StripHeaders that aren't in c.PreserveHeaders from h.
+func (c *Config) stripHeaders(h http.Header) {
+	for _, name := range c.StripHeaders {
+		preserved := slices.ContainsFunc(c.PreserveHeaders, func(preserved string) bool {
+			return strings.EqualFold(preserved, name)
 		})
+		if !preserved {
+			h.Del(name)
+		}
+	}
+}
+
+// delTrailers deletes the trailers declared in h (and their values, if they were set), and the trailers set with http.TrailerPrefix.
+func delTrailers(h http.Header) {
+	for _, v := range h.Values("Trailer") {
+		for key := range strings.SplitSeq(v, ",") {
+			h.Del(strings.TrimSpace(key))
+		}
+	}
+	h.Del("Trailer")
+
+	for key := range h {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			delete(h, key)
+		}
 	}
 }
 
+// ShouldConvert reports whether an error response with the given status should be converted to a problem details response,
+// i.e. whether the status is >= 400 and allows a body. It's the same decision ProblemDetailsConverter makes,
+// so custom middleware can use it to stay consistent with the converter.
+func ShouldConvert(status int) bool {
+	return bodyAllowed(status) && status >= 400
+}
+
+// bodyAllowed reports whether a response with the given status may have a body.
+func bodyAllowed(status int) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusResetContent, http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
 var interceptorPool = sync.Pool{
 	New: func() any {
 		return &responseInterceptor{}
@@ -147,6 +549,39 @@ type responseInterceptor struct {
 	http.ResponseWriter
 	status      int
 	bodyWritten bool
+
+	impliedStatus func(r *http.Request, header http.Header) int
+	r             *http.Request
+
+	captureLimit int    // The maximum size of error response bodies to capture for conversion, or 0 to not capture them.
+	captured     []byte // The captured body of the error response, if any. It's only written if the response isn't converted after all.
+	skipHeader   string // The header with which the handler opts the response out of conversion, or "" if none.
+}
+
+// skipped reports whether the handler opted the response out of conversion by setting ri.skipHeader.
+func (ri *responseInterceptor) skipped() bool {
+	return ri.skipHeader != "" && ri.Header().Get(ri.skipHeader) != ""
+}
+
+// writeHeader writes the status to the underlying writer, deleting ri.skipHeader first so it's never sent to the client.
+func (ri *responseInterceptor) writeHeader() {
+	if ri.skipHeader != "" {
+		ri.Header().Del(ri.skipHeader)
+	}
+	ri.ResponseWriter.WriteHeader(ri.status)
+}
+
+// implyStatus sets the status if WriteHeader hasn't been called, using impliedStatus if it's not nil, or http.StatusOK.
+func (ri *responseInterceptor) implyStatus() {
+	if ri.status != 0 {
+		return
+	}
+	if ri.impliedStatus != nil {
+		ri.status = ri.impliedStatus(ri.r, ri.Header())
+	}
+	if ri.status == 0 {
+		ri.status = http.StatusOK
+	}
 }
 
 func (ri *responseInterceptor) WriteHeader(status int) {
@@ -154,15 +589,48 @@ func (ri *responseInterceptor) WriteHeader(status int) {
 }
 
 func (ri *responseInterceptor) Write(body []byte) (int, error) {
+	if !ri.bodyWritten {
+		ri.implyStatus()
+	}
 	if ri.status >= 400 && len(body) == 0 {
 		return 0, nil
 	}
-	if !ri.bodyWritten { // handle things like maybeWriteHeader() in wrap_writer.go in github.com/go-chi/chi/v5@v5.2.2/middleware/wrap_writer.go:116
-		if ri.status == 0 {
-			ri.status = http.StatusOK
+	if !ri.bodyWritten && ri.captureLimit > 0 && ShouldConvert(ri.status) && !ri.skipped() && !strings.HasPrefix(ri.Header().Get("Content-Type"), "application/problem+json") {
+		if len(ri.captured)+len(body) <= ri.captureLimit {
+			ri.captured = append(ri.captured, body...)
+			return len(body), nil
 		}
-		ri.ResponseWriter.WriteHeader(ri.status)
+		// The body is too large to be converted, so pass the response through, starting with the part that was captured.
+		ri.writeHeader()
+		ri.bodyWritten = true
+		if len(ri.captured) > 0 {
+			if _, err := ri.ResponseWriter.Write(ri.captured); err != nil {
+				return 0, err
+			}
+			ri.captured = ri.captured[:0]
+		}
+	}
+	if !ri.bodyWritten { // handle things like maybeWriteHeader() in wrap_writer.go in github.com/go-chi/chi/v5@v5.2.2/middleware/wrap_writer.go:116
+		ri.writeHeader()
 	}
 	ri.bodyWritten = true
 	return ri.ResponseWriter.Write(body)
 }
+
+// Flush flushes the response, unless it's an error response that hasn't been written yet, since it might still be converted.
+func (ri *responseInterceptor) Flush() {
+	if !ri.bodyWritten {
+		ri.implyStatus()
+		if ShouldConvert(ri.status) && !ri.skipped() {
+			return
+		}
+		ri.writeHeader()
+		ri.bodyWritten = true
+	}
+	_ = http.NewResponseController(ri.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController and other middlewares can discover its capabilities.
+func (ri *responseInterceptor) Unwrap() http.ResponseWriter {
+	return ri.ResponseWriter
+}
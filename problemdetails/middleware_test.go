@@ -8,17 +8,22 @@
 package problemdetails
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 const panicMessage = "foo"
@@ -75,6 +80,234 @@ func TestRecovererWithoutCaller(t *testing.T) {
 	}
 }
 
+func TestRecovererCommittedResponse(t *testing.T) {
+	logs := &strings.Builder{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	r := chi.NewRouter()
+	r.Use(Recoverer(-1))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		panic(panicMessage)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+
+	r.ServeHTTP(w, req)
+
+	assertEqual(t, w.Code, http.StatusOK)
+	assertEqual(t, w.Body.String(), "partial")
+	assertEqual(t, w.Header().Get("Content-Type"), "")
+	if !strings.Contains(logs.String(), "recovered from panic after the response was committed") || !strings.Contains(logs.String(), panicMessage) {
+		t.Fatal("expected the panic to be logged, got: " + logs.String())
+	}
+}
+
+func TestRecovererPanicBody(t *testing.T) {
+	logs := &strings.Builder{}
+	var pdCtx *Context
+	var read string
+
+	c := NewConfig(
+		WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		WithPanicBody(8, func(body []byte) string { return strings.ReplaceAll(string(body), "secret", "******") }),
+	)
+	r := chi.NewRouter()
+	r.Use(ProblemDetailsContext)
+	r.Use(c.Recoverer())
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		pdCtx = FromContext(r.Context())
+		body, _ := io.ReadAll(r.Body)
+		read = string(body)
+		panic(panicMessage)
+	})
+	r.Post("/committed", func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Write([]byte("partial"))
+		panic(panicMessage)
+	})
+	r.Post("/ok", func(w http.ResponseWriter, r *http.Request) {
+		pdCtx = FromContext(r.Context())
+		body, _ := io.ReadAll(r.Body)
+		read = string(body)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("secret=1&user=bob")))
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, read, "secret=1&user=bob")
+	assertEqual(t, pdCtx.PanicBody(), "******=1")
+	if strings.Contains(w.Body.String(), "secret") || strings.Contains(w.Body.String(), "******") {
+		t.Fatal("expected the body snippet not to be written to the response, got: " + w.Body.String())
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/committed", strings.NewReader("a=b")))
+	if !strings.Contains(logs.String(), `body="a=b"`) {
+		t.Fatal("expected the body snippet to be logged, got: " + logs.String())
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/ok", strings.NewReader("secret=1&user=bob")))
+	assertEqual(t, read, "secret=1&user=bob")
+	assertEqual(t, pdCtx.PanicBody(), "")
+
+	r = chi.NewRouter()
+	r.Use(ProblemDetailsContext)
+	r.Use(Recoverer(-1))
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		pdCtx = FromContext(r.Context())
+		if _, ok := r.Body.(io.WriterTo); !ok {
+			t.Error("expected the request body to be left untouched when disabled")
+		}
+		panic(panicMessage)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("secret")))
+	assertEqual(t, pdCtx.PanicBody(), "")
+
+	logs.Reset()
+	r = chi.NewRouter()
+	r.Use(ProblemDetailsContext)
+	r.Use(NewConfig(WithLogger(slog.New(slog.NewTextHandler(logs, nil))), WithPanicBody(8, nil)).Recoverer())
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		pdCtx = FromContext(r.Context())
+		if _, ok := r.Body.(io.WriterTo); !ok {
+			t.Error("expected the request body to be left untouched without a redact function")
+		}
+		w.Write([]byte("partial"))
+		panic(panicMessage)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("secret")))
+	assertEqual(t, pdCtx.PanicBody(), "")
+	if strings.Contains(logs.String(), "secret") {
+		t.Fatal("expected the raw body not to be logged without a redact function, got: " + logs.String())
+	}
+}
+
+func TestRecovererUpgrade(t *testing.T) {
+	logs := &strings.Builder{}
+	c := NewConfig(WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+
+	upgradedDone := make(chan struct{})
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/upgraded" {
+				defer close(upgradedDone)
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Use(c.Recoverer())
+	r.Get("/unauthorized", func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusUnauthorized, "Missing token.", "")
+	})
+	r.Get("/panic", panickingHandler)
+	r.Get("/upgraded", func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		panic(panicMessage)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	newUpgradeRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		return req
+	}
+
+	res, err := http.DefaultClient.Do(newUpgradeRequest("/unauthorized"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	assertEqual(t, res.StatusCode, http.StatusUnauthorized)
+	assertEqual(t, res.Header.Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, string(body), `{"type":"https://problems-registry.smartbear.com/unauthorized","status":401,"title":"Unauthorized","detail":"Missing token."}`+"\n")
+
+	res, err = http.DefaultClient.Do(newUpgradeRequest("/panic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	assertEqual(t, res.StatusCode, http.StatusInternalServerError)
+	assertEqual(t, res.Header.Get("Content-Type"), "application/problem+json; charset=utf-8")
+
+	res, err = http.DefaultClient.Do(newUpgradeRequest("/upgraded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	assertEqual(t, res.StatusCode, http.StatusSwitchingProtocols)
+	<-upgradedDone
+	if !strings.Contains(logs.String(), "recovered from panic after the response was committed") {
+		t.Fatal("expected the panic after the upgrade to be logged, got: " + logs.String())
+	}
+}
+
+func TestProblemFromPanic(t *testing.T) {
+	recoverProblem := func(fn func(), opts ...Option) (pd *ProblemDetails) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				pd = ProblemFromPanic(rec, opts...)
+			}
+		}()
+		fn()
+		return nil
+	}
+
+	_, file, line, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("could not get current file path")
+	}
+	panicking := func() { panic(panicMessage) } // 4 lines after runtime.Caller.
+
+	pd := recoverProblem(panicking, WithStackFrames(0), WithPanicType("https://example.com/probs/panic", "Unexpected panic"))
+	assertEqual(t, pd, &ProblemDetails{
+		Type:   "https://example.com/probs/panic",
+		Status: http.StatusInternalServerError,
+		Title:  "Unexpected panic",
+		Detail: fmt.Sprintf("panic: '%s' at %s:%d", panicMessage, file, line+4),
+	})
+
+	pd = recoverProblem(panicking)
+	assertEqual(t, pd, &ProblemDetails{Status: http.StatusInternalServerError, Detail: "panic: '" + panicMessage + "'"})
+
+	// The same frame index refers to the same frame as with Recoverer.
+	r := chi.NewRouter()
+	r.Use(Recoverer(0))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) { panicking() })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	written := &ProblemDetails{}
+	if err := json.Unmarshal(w.Body.Bytes(), written); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, written.Detail, fmt.Sprintf("panic: '%s' at %s:%d", panicMessage, file, line+4))
+}
+
 func TestRecovererAbortHandler(t *testing.T) {
 	defer func() {
 		rcv := recover()
@@ -100,6 +333,768 @@ func TestRecovererAbortHandler(t *testing.T) {
 	r.ServeHTTP(w, req)
 }
 
+func TestProblemDetailsContextInstanceAndType(t *testing.T) {
+	r := chi.NewRouter()
+
+	r.Use(ProblemDetailsContext)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetInstance(r.Context(), "/users/{id}")
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusNotFound, "", "")
+	})
+	r.Get("/users/{id}/typed", func(w http.ResponseWriter, r *http.Request) {
+		SetType(r.Context(), "https://example.com/probs/user-not-found")
+		Write(w, r, http.StatusNotFound, "", "")
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, resBody := testRequest(t, ts, "GET", "/users/1", nil)
+	assertEqual(t, res.StatusCode, http.StatusNotFound)
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal([]byte(resBody), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Instance, "/users/{id}")
+	assertEqual(t, pd.Type, "https://problems-registry.smartbear.com/not-found")
+
+	res, resBody = testRequest(t, ts, "GET", "/users/1/typed", nil)
+	assertEqual(t, res.StatusCode, http.StatusNotFound)
+
+	pd = &ProblemDetails{}
+	if err := json.Unmarshal([]byte(resBody), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Instance, "/users/{id}")
+	assertEqual(t, pd.Type, "https://example.com/probs/user-not-found")
+}
+
+type testCtxKey struct{ name string }
+
+func TestProblemDetailsContextCustomKey(t *testing.T) {
+	c1 := NewConfig(WithContextKey(testCtxKey{"1"}))
+	c2 := NewConfig(WithContextKey(testCtxKey{"2"}))
+
+	var pdCtx1, pdCtx2, defaultPdCtx *Context
+
+	r := chi.NewRouter()
+	r.Use(c1.ProblemDetailsContext)
+	r.Use(c2.ProblemDetailsContext)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c2.FromContext(r.Context()).SetInstance("/users/{id}")
+			next.ServeHTTP(w, r)
+			pdCtx1, pdCtx2, defaultPdCtx = c1.FromContext(r.Context()), c2.FromContext(r.Context()), FromContext(r.Context())
+		})
+	})
+	r.Get("/1", func(w http.ResponseWriter, r *http.Request) {
+		c1.Write(w, r, http.StatusNotFound, "", "")
+	})
+	r.Get("/2", func(w http.ResponseWriter, r *http.Request) {
+		c2.Write(w, r, http.StatusConflict, "", "")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/1", nil))
+
+	assertEqual(t, pdCtx1.Details().Status, http.StatusNotFound)
+	assertEqual(t, pdCtx1.Details().Instance, "")
+	assertEqual(t, pdCtx2.Details(), (*ProblemDetails)(nil))
+	assertEqual(t, defaultPdCtx, (*Context)(nil))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/2", nil))
+
+	assertEqual(t, pdCtx1.Details(), (*ProblemDetails)(nil))
+	assertEqual(t, pdCtx2.Details().Status, http.StatusConflict)
+	assertEqual(t, pdCtx2.Details().Instance, "/users/{id}")
+}
+
+func TestMountWithTypeBase(t *testing.T) {
+	writeOutOfCredit := func(pdw *Writer) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			pdw.WriteProblem(w, r, &ProblemDetails{Type: "out-of-credit", Status: http.StatusForbidden})
+		}
+	}
+
+	for _, tt := range []struct {
+		baseURI string
+		want    map[string]string
+	}{
+		{"", map[string]string{
+			"/out-of-credit":                  "out-of-credit",
+			"/billing/out-of-credit":          "https://example.com/probs/billing/out-of-credit",
+			"/billing/invoices/out-of-credit": "https://example.com/probs/billing/invoices/out-of-credit",
+			"/billing/shipping/out-of-credit": "https://shipping.example/probs/out-of-credit",
+			"/relative/out-of-credit":         "out-of-credit",
+			"/relative/nested/out-of-credit":  "out-of-credit",
+		}},
+		{"https://example.com/api/", map[string]string{
+			"/out-of-credit":                  "https://example.com/api/out-of-credit",
+			"/billing/out-of-credit":          "https://example.com/probs/billing/out-of-credit",
+			"/billing/invoices/out-of-credit": "https://example.com/probs/billing/invoices/out-of-credit",
+			"/billing/shipping/out-of-credit": "https://shipping.example/probs/out-of-credit",
+			"/relative/out-of-credit":         "https://example.com/api/relative/out-of-credit",
+			"/relative/nested/out-of-credit":  "https://example.com/api/relative/nested/out-of-credit",
+		}},
+	} {
+		pdw := &Writer{BaseURI: tt.baseURI}
+
+		invoices := chi.NewRouter()
+		invoices.Get("/out-of-credit", writeOutOfCredit(pdw))
+
+		billing := chi.NewRouter()
+		billing.Get("/out-of-credit", writeOutOfCredit(pdw))
+		billing.Mount("/invoices", MountWithTypeBase("invoices/", invoices))
+		billing.Mount("/shipping", MountWithTypeBase("https://shipping.example/probs/", invoices))
+
+		nested := chi.NewRouter()
+		nested.Get("/out-of-credit", writeOutOfCredit(pdw))
+
+		relative := chi.NewRouter()
+		relative.Get("/out-of-credit", writeOutOfCredit(pdw))
+		relative.Mount("/nested", MountWithTypeBase("nested/", nested))
+
+		r := chi.NewRouter()
+		r.Get("/out-of-credit", writeOutOfCredit(pdw))
+		r.Mount("/billing", MountWithTypeBase("https://example.com/probs/billing/", billing))
+		r.Mount("/relative", MountWithTypeBase("relative/", relative))
+
+		for path, want := range tt.want {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+			pd := &ProblemDetails{}
+			if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, pd.Type, want)
+		}
+	}
+}
+
+func TestRedactServerErrors(t *testing.T) {
+	var pdCtx *Context
+	pdw := &Writer{RedactServerErrors: true}
+
+	r := chi.NewRouter()
+	r.Use(ProblemDetailsContext)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			pdCtx = r.Context().Value(CtxKey).(*Context)
+		})
+	})
+	r.Get("/500", func(w http.ResponseWriter, r *http.Request) {
+		pdw.Write(w, r, http.StatusInternalServerError, "pq: connection refused", "")
+	})
+	r.Get("/400", func(w http.ResponseWriter, r *http.Request) {
+		pdw.Write(w, r, http.StatusBadRequest, "Missing name.", "")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/500", nil))
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/server-error","status":500,"title":"Internal Server Error"}`+"\n")
+	assertEqual(t, pdCtx.Details().Detail, "pq: connection refused")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/400", nil))
+
+	assertEqual(t, w.Code, http.StatusBadRequest)
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","detail":"Missing name."}`+"\n")
+	assertEqual(t, pdCtx.Details().Detail, "Missing name.")
+}
+
+func TestWithProblemHook(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(WithProblemHook(func(r *http.Request, pd *ProblemDetails) {
+		pd.Code += "first"
+	}))
+	r.Use(WithProblemHook(func(r *http.Request, pd *ProblemDetails) {
+		pd.Code += ",second:" + r.URL.Path
+	}))
+	r.Use(Recoverer(-1))
+	r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/write", func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusBadRequest, "", "")
+	})
+	r.Get("/problem", func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, r, &ProblemDetails{Status: http.StatusConflict})
+	})
+	r.Get("/convert", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/panic", panickingHandler)
+
+	for _, path := range []string{"/write", "/problem", "/convert", "/panic"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, pd.Code, "first,second:"+path)
+	}
+}
+
+func TestRevealServerErrors(t *testing.T) {
+	const token = "internal-secret"
+	pdw := &Writer{
+		RedactServerErrors: true,
+		RevealServerErrors: func(r *http.Request) bool { return r.Header.Get("X-Internal-Token") == token },
+	}
+	c := NewConfig(WithWriter(pdw), WithStackFrames(0))
+
+	r := chi.NewRouter()
+	r.Use(c.Recoverer())
+	r.Get("/500", func(w http.ResponseWriter, r *http.Request) {
+		pdw.Write(w, r, http.StatusInternalServerError, "pq: connection refused", "")
+	})
+	r.Get("/panic", panickingHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/500", nil))
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/server-error","status":500,"title":"Internal Server Error"}`+"\n")
+
+	req := httptest.NewRequest("GET", "/500", nil)
+	req.Header.Set("X-Internal-Token", token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/server-error","status":500,"title":"Internal Server Error","detail":"pq: connection refused"}`+"\n")
+
+	for token, revealed := range map[string]bool{"": false, "guess": false, token: true} {
+		req := httptest.NewRequest("GET", "/panic", nil)
+		req.Header.Set("X-Internal-Token", token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, strings.HasPrefix(pd.Detail, "panic: '"+panicMessage+"' at "), revealed)
+	}
+}
+
+func TestSetInstanceWithoutContext(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	SetInstance(r.Context(), "/foo")
+	SetType(r.Context(), "https://example.com/probs/foo")
+	Write(w, r, http.StatusBadRequest, "", "")
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Instance, "")
+	assertEqual(t, pd.Type, "https://problems-registry.smartbear.com/bad-request")
+}
+
+func TestSetProblem(t *testing.T) {
+	var pdCtx *Context
+	manual := &ProblemDetails{Status: http.StatusTooManyRequests, Title: "Slow down", Code: "RATE_LIMITED"}
+
+	r := chi.NewRouter()
+	r.Use(ProblemDetailsContext)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		pdCtx = FromContext(r.Context())
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+		SetProblem(r.Context(), manual)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Body.String(), "slow down")
+	assertEqual(t, pdCtx.Details() == manual, true)
+	assertEqual(t, pdCtx.RespWriteError(), nil)
+
+	SetProblem(httptest.NewRequest("GET", "/", nil).Context(), manual)
+	(*Context)(nil).SetProblem(manual)
+}
+
+func TestShouldConvert(t *testing.T) {
+	tests := map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusNotModified:         false,
+		399:                            false,
+		http.StatusBadRequest:          true,
+		http.StatusInternalServerError: true,
+		599:                            true,
+		600:                            true,
+	}
+	for status, want := range tests {
+		assertEqual(t, ShouldConvert(status), want)
+	}
+}
+
+func TestProblemDetailsConverterNoContent(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusResetContent, http.StatusNotModified} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			r := chi.NewRouter()
+
+			r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {
+				t.Fatalf("response with status %d should not be converted", status)
+			}))
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(status)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+
+			r.ServeHTTP(w, req)
+
+			assertEqual(t, w.Code, status)
+			assertEqual(t, w.Body.Len(), 0)
+			assertEqual(t, w.Header().Get("Content-Type"), "text/plain")
+		})
+	}
+}
+
+func TestProblemDetailsConverterNoContentLength(t *testing.T) {
+	r := chi.NewRouter()
+
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "42")
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2")
+		w.Write([]byte("ok"))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Code, http.StatusNoContent)
+	assertEqual(t, w.Body.Len(), 0)
+	assertEqual(t, w.Header().Values("Content-Length"), []string(nil))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/ok", nil))
+
+	assertEqual(t, w.Code, http.StatusOK)
+	assertEqual(t, w.Header().Get("Content-Length"), "2")
+}
+
+func TestProblemDetailsConverterPreservesValidators(t *testing.T) {
+	r := chi.NewRouter()
+
+	r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Code, http.StatusUnavailableForLegalReasons)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Header().Get("ETag"), `"v1"`)
+	assertEqual(t, w.Header().Get("Last-Modified"), "Wed, 21 Oct 2015 07:28:00 GMT")
+}
+
+func TestProblemDetailsConverterStripHeaders(t *testing.T) {
+	newRouter := func(c *Config) *chi.Mux {
+		r := chi.NewRouter()
+		r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) {}))
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Vary", "Accept-Language")
+			w.Header().Set("Content-Language", "en")
+			w.WriteHeader(http.StatusNotFound)
+		})
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	newRouter(DefaultConfig()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Header().Get("ETag"), `"v1"`)
+	assertEqual(t, w.Header().Get("Vary"), "")
+	assertEqual(t, w.Header().Get("Content-Language"), "en")
+
+	w = httptest.NewRecorder()
+	newRouter(NewConfig(WithStripHeaders("ETag", "Vary", "Content-Language"), WithPreserveHeaders("vary"))).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Header().Get("ETag"), "")
+	assertEqual(t, w.Header().Get("Vary"), "Accept-Language")
+	assertEqual(t, w.Header().Get("Content-Language"), "")
+}
+
+func TestProblemDetailsConverterOnConvert(t *testing.T) {
+	type conversion struct {
+		status    int
+		converted bool
+	}
+	var conversions []conversion
+	var callbacks int
+
+	c := NewConfig(WithOnConvert(func(r *http.Request, status int, converted bool, duration time.Duration) {
+		conversions = append(conversions, conversion{status, converted})
+		if r.URL.Path == "/slow" && duration < 10*time.Millisecond {
+			t.Errorf("expected the handler duration to be at least 10ms, got: %s", duration)
+		}
+	}))
+
+	r := chi.NewRouter()
+	r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) { callbacks++ }))
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	r.Get("/empty", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/problem", func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusBadRequest, "", "")
+	})
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	for _, path := range []string{"/ok", "/empty", "/problem", "/slow"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assertEqual(t, conversions, []conversion{
+		{http.StatusOK, false},
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusServiceUnavailable, true},
+	})
+	assertEqual(t, callbacks, 1)
+}
+
+func TestProblemDetailsConverterOriginalBody(t *testing.T) {
+	newRouter := func(c *Config) *chi.Mux {
+		r := chi.NewRouter()
+		r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) {}))
+		r.Get("/json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"error":"bad"}`))
+		})
+		r.Get("/text", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		})
+		r.Get("/large", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(strings.Repeat("a", 10)))
+			w.Write([]byte(strings.Repeat("b", 10)))
+		})
+		return r
+	}
+
+	r := newRouter(NewConfig(WithOriginalBody(16)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/json", nil))
+
+	assertEqual(t, w.Code, http.StatusUnprocessableEntity)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":422,"title":"Unprocessable Entity","original":{"error":"bad"}}`+"\n")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/text", nil))
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","detail":"not found"}`+"\n")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/large", nil))
+
+	assertEqual(t, w.Code, http.StatusBadRequest)
+	assertEqual(t, w.Body.String(), strings.Repeat("a", 10)+strings.Repeat("b", 10))
+
+	w = httptest.NewRecorder()
+	newRouter(DefaultConfig()).ServeHTTP(w, httptest.NewRequest("GET", "/text", nil))
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Body.String(), "not found\n")
+}
+
+func TestProblemDetailsConverterSkipHeader(t *testing.T) {
+	newRouter := func(c *Config) *chi.Mux {
+		r := chi.NewRouter()
+		r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) {}))
+		r.Get("/oauth", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Skip-Problem-Conversion", "1")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+		})
+		r.Get("/empty", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Skip-Problem-Conversion", "1")
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+		r.Get("/custom", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Raw-Error", "yes")
+			w.WriteHeader(http.StatusNotFound)
+		})
+		r.Get("/convert", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		return r
+	}
+
+	for _, c := range []*Config{DefaultConfig(), NewConfig(WithOriginalBody(1024))} {
+		r := newRouter(c)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/oauth", nil))
+		assertEqual(t, w.Code, http.StatusBadRequest)
+		assertEqual(t, w.Header().Get("Content-Type"), "application/json")
+		assertEqual(t, w.Header().Get("X-Skip-Problem-Conversion"), "")
+		assertEqual(t, w.Body.String(), `{"error":"invalid_grant"}`)
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/empty", nil))
+		assertEqual(t, w.Code, http.StatusUnauthorized)
+		assertEqual(t, w.Header().Get("X-Skip-Problem-Conversion"), "")
+		assertEqual(t, w.Body.String(), "")
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/convert", nil))
+		assertEqual(t, w.Code, http.StatusNotFound)
+		assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	}
+
+	r := newRouter(NewConfig(WithSkipConversionHeader("X-Raw-Error")))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/custom", nil))
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Header().Get("X-Raw-Error"), "")
+	assertEqual(t, w.Body.String(), "")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/empty", nil))
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+}
+
+func TestProblemDetailsConverterCacheControl(t *testing.T) {
+	r := chi.NewRouter()
+
+	r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	r.Get("/cacheable", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=86400")
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, w.Header().Get("Cache-Control"), "no-store")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/cacheable", nil))
+
+	assertEqual(t, w.Code, http.StatusUnavailableForLegalReasons)
+	assertEqual(t, w.Header().Get("Cache-Control"), "max-age=86400")
+}
+
+func TestProblemDetailsConverterTrailers(t *testing.T) {
+	r := chi.NewRouter()
+
+	r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("body"))
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Count", "1")
+	})
+	r.Get("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum, X-Count")
+		w.Header().Set(http.TrailerPrefix+"X-Elapsed", "1ms")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("X-Checksum", "abc")
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, resBody := testRequest(t, ts, "GET", "/ok", nil)
+	assertEqual(t, resBody, "body")
+	assertEqual(t, res.Trailer, http.Header{"X-Checksum": {"abc"}, "X-Count": {"1"}})
+
+	res, _ = testRequest(t, ts, "GET", "/error", nil)
+	assertEqual(t, res.StatusCode, http.StatusInternalServerError)
+	assertEqual(t, res.Header.Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, res.Header.Get("Trailer"), "")
+	assertEqual(t, res.Header.Get("X-Checksum"), "")
+	assertEqual(t, len(res.Trailer), 0)
+}
+
+type statusCtxKey struct{}
+
+func TestProblemDetailsConverterImpliedStatus(t *testing.T) {
+	newRouter := func(c *Config) *chi.Mux {
+		r := chi.NewRouter()
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := new(int)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), statusCtxKey{}, status)))
+			})
+		})
+		r.Use(c.ProblemDetailsConverter(func(r *http.Request, status int) {}))
+		r.Get("/body", func(w http.ResponseWriter, r *http.Request) {
+			*r.Context().Value(statusCtxKey{}).(*int) = http.StatusBadRequest
+			w.Write([]byte("oops"))
+		})
+		r.Get("/empty", func(w http.ResponseWriter, r *http.Request) {
+			*r.Context().Value(statusCtxKey{}).(*int) = http.StatusBadRequest
+		})
+		return r
+	}
+
+	// Without WithImpliedStatus, forgetting to call WriteHeader means 200.
+	r := newRouter(DefaultConfig())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/body", nil))
+
+	assertEqual(t, w.Code, http.StatusOK)
+	assertEqual(t, w.Body.String(), "oops")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/empty", nil))
+
+	assertEqual(t, w.Code, http.StatusOK)
+	assertEqual(t, w.Body.Len(), 0)
+
+	r = newRouter(NewConfig(WithImpliedStatus(func(r *http.Request, header http.Header) int {
+		return *r.Context().Value(statusCtxKey{}).(*int)
+	})))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/body", nil))
+
+	assertEqual(t, w.Code, http.StatusBadRequest)
+	assertEqual(t, w.Body.String(), "oops")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/empty", nil))
+
+	assertEqual(t, w.Code, http.StatusBadRequest)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+}
+
+func TestProblemDetailsConverterCompression(t *testing.T) {
+	notFound := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }
+
+	t.Run("inside", func(t *testing.T) {
+		r := chi.NewRouter()
+		r.Use(middleware.Compress(5, "application/problem+json"))
+		r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+		r.Get("/", notFound)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		r.ServeHTTP(w, req)
+
+		assertEqual(t, w.Code, http.StatusNotFound)
+		assertEqual(t, w.Header().Get("Content-Encoding"), "gzip")
+
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pd := &ProblemDetails{}
+		if err := json.NewDecoder(gr).Decode(pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, pd.Status, http.StatusNotFound)
+	})
+
+	t.Run("outside", func(t *testing.T) {
+		r := chi.NewRouter()
+		r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+		r.Use(middleware.Compress(5, "application/problem+json"))
+		r.Get("/", notFound)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		r.ServeHTTP(w, req)
+
+		assertEqual(t, w.Code, http.StatusNotFound)
+		assertEqual(t, w.Header().Get("Content-Encoding"), "")
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, pd.Status, http.StatusNotFound)
+	})
+}
+
+func TestProblemDetailsConverterResponseController(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(ProblemDetailsConverter(func(r *http.Request, status int) {}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("ok"))
+		if err := rc.Flush(); err != nil {
+			t.Error(err)
+		}
+	})
+	r.Get("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, resBody := testRequest(t, ts, "GET", "/", nil)
+	assertEqual(t, res.StatusCode, http.StatusOK)
+	assertEqual(t, resBody, "ok")
+
+	res, _ = testRequest(t, ts, "GET", "/error", nil)
+	assertEqual(t, res.StatusCode, http.StatusBadRequest)
+	assertEqual(t, res.Header.Get("Content-Type"), "application/problem+json; charset=utf-8")
+}
+
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, body io.Reader) (*http.Response, string) {
 	req, err := http.NewRequest(method, ts.URL+path, body)
 	if err != nil {
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+)
+
+// DefaultMaxBodyBytes is the default maximum size of problem details response bodies read by a Parser.
+const DefaultMaxBodyBytes = 1 << 20 // 1 MiB
+
+// ErrNotProblemDetails is returned when parsing a response that isn't a problem details response.
+var ErrNotProblemDetails = errors.New("problemdetails: not a problem details response")
+
+// Parser parses problem details responses, e.g. from upstream services.
+type Parser struct {
+	MaxBodyBytes int64 // The maximum size of the response body. Larger bodies are rejected with an error instead of being read entirely. If <= 0, DefaultMaxBodyBytes is used.
+}
+
+var defaultParser = &Parser{}
+
+// ParseResponse parses resp as a problem details response using the default parser. See Parser.ParseResponse.
+func ParseResponse(resp *http.Response) (*ProblemDetails, error) {
+	return defaultParser.ParseResponse(resp)
+}
+
+// ParseResponse reads and parses a problem details object from the body of resp. The body is not closed.
+//
+// If the Content-Type of resp isn't "application/problem+json", ErrNotProblemDetails is returned.
+// If the body is larger than p.MaxBodyBytes, an error is returned after reading at most p.MaxBodyBytes + 1 bytes.
+// If the problem details object has no status, it's set to the status code of resp.
+func (p *Parser) ParseResponse(resp *http.Response) (*ProblemDetails, error) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/problem+json" {
+		return nil, ErrNotProblemDetails
+	}
+
+	maxBodyBytes := p.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	// Read one more byte than the limit to detect larger bodies, without overflowing for a limit of math.MaxInt64.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, min(maxBodyBytes, math.MaxInt64-1)+1))
+	if err != nil {
+		return nil, fmt.Errorf("problemdetails: reading response body: %w", err)
+	}
+	if int64(len(body)) > maxBodyBytes {
+		return nil, fmt.Errorf("problemdetails: response body exceeds the limit of %d bytes", maxBodyBytes)
+	}
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal(body, pd); err != nil {
+		return nil, fmt.Errorf("problemdetails: parsing response body: %w", err)
+	}
+	if pd.Status == 0 {
+		pd.Status = resp.StatusCode
+	}
+
+	return pd, nil
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	WriteProblem(w, r, &ProblemDetails{Status: http.StatusForbidden, Detail: "No.", Extensions: map[string]any{"balance": 30}})
+
+	pd, err := ParseResponse(w.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd, &ProblemDetails{
+		Type:       "https://problems-registry.smartbear.com/forbidden",
+		Status:     http.StatusForbidden,
+		Title:      "Forbidden",
+		Detail:     "No.",
+		Extensions: map[string]any{"balance": 30.0},
+	})
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusTeapot)
+	w.WriteString(`{"title":"I'm a teapot"}`)
+
+	pd, err = ParseResponse(w.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Status, http.StatusTeapot)
+
+	w = httptest.NewRecorder()
+	http.Error(w, "not found", http.StatusNotFound)
+
+	if _, err := ParseResponse(w.Result()); !errors.Is(err, ErrNotProblemDetails) {
+		t.Fatalf("expected ErrNotProblemDetails, got: %v", err)
+	}
+}
+
+func TestParseResponseMaxBodyBytes(t *testing.T) {
+	newResponse := func(body string) *http.Response {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.WriteString(body)
+		return w.Result()
+	}
+
+	body := `{"status":400,"detail":"` + strings.Repeat("a", 100) + `"}`
+	p := &Parser{MaxBodyBytes: int64(len(body))}
+
+	if _, err := p.ParseResponse(newResponse(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := p.ParseResponse(newResponse(body + " "))
+	if err == nil || err.Error() != "problemdetails: response body exceeds the limit of 126 bytes" {
+		t.Fatalf("expected a limit error, got: %v", err)
+	}
+
+	_, err = ParseResponse(newResponse(`{"detail":"` + strings.Repeat("a", DefaultMaxBodyBytes) + `"}`))
+	if err == nil || !strings.HasPrefix(err.Error(), "problemdetails: response body exceeds the limit") {
+		t.Fatalf("expected a limit error, got: %v", err)
+	}
+	p = &Parser{MaxBodyBytes: math.MaxInt64}
+	if _, err := p.ParseResponse(newResponse(body)); err != nil {
+		t.Fatal(err)
+	}
+}
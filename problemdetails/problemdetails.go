@@ -4,12 +4,22 @@
 package problemdetails
 
 import (
-	"bytes"
-	"encoding/json"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
-// This is not meant to be used directly. Only read from if using `problemdetails.ProblemDetailsContext`.
+// ProblemDetails is a RFC 9457 problem details object.
+// It's usually created by the write functions, which record it in the `problemdetails.Context` when using `problemdetails.ProblemDetailsContext`,
+// but it can also be created directly and written with WriteProblem, to set fields that the other write functions don't.
 type ProblemDetails struct {
 	Schema string `json:"$schema,omitempty"`
 
@@ -24,6 +34,180 @@ type ProblemDetails struct {
 
 	Code   string  `json:"code,omitempty"`   // [AdditionalMember] An API specific error code aiding the provider team understand the error based on their own potential taxonomy or registry.
 	Errors []Error `json:"errors,omitempty"` // [AdditionalMember] An array of error details to accompany a problem details response.
+	Links  []Link  `json:"links,omitempty"`  // [AdditionalMember] An array of RFC 8288 web links related to the problem, e.g. to its documentation.
+
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"` // [AdditionalMember] An array of the invalid request parameters and why they're invalid, as in the validation example of RFC 7807 and RFC 9457.
+
+	// Additional extension members, serialized as top-level members of the problem details object (sorted by name).
+	// Members whose names collide with the fields above are ignored.
+	Extensions map[string]any `json:"-"`
+
+	cause          error          // The underlying error, if any. Never serialized.
+	durationFormat DurationFormat // The format of time.Duration extension values.
+}
+
+// Problem returns a new problem details object with the given status and its title (see StatusText), ready to be written with WriteProblem
+// or returned as an error. The type is left empty so it's filled in when writing.
+func Problem(status int) *ProblemDetails {
+	return &ProblemDetails{Status: status, Title: StatusText(status)}
+}
+
+// Problemf is like Problem, but also sets the detail formatted according to format, as with fmt.Sprintf.
+// If format wraps errors with the %w verb (as with fmt.Errorf), the formatted error is set as the cause, so errors.Is and errors.As see them.
+func Problemf(status int, format string, args ...any) *ProblemDetails {
+	err := fmt.Errorf(format, args...)
+	pd := Problem(status)
+	pd.Detail = err.Error()
+	switch err.(type) {
+	case interface{ Unwrap() error }, interface{ Unwrap() []error }:
+		pd.cause = err
+	}
+	return pd
+}
+
+// Error implements the error interface so a *ProblemDetails can be returned and inspected as an error.
+func (pd *ProblemDetails) Error() string {
+	if pd.Detail == "" {
+		return fmt.Sprintf("%d %s", pd.Status, pd.Title)
+	}
+	return fmt.Sprintf("%d %s: %s", pd.Status, pd.Title, pd.Detail)
+}
+
+// WithCause sets err as the underlying cause of pd and returns pd.
+// The cause is only available server-side (via Unwrap, errors.Is and errors.As) and is never written to the response body.
+func (pd *ProblemDetails) WithCause(err error) *ProblemDetails {
+	pd.cause = err
+	return pd
+}
+
+// Unwrap returns the underlying cause set with WithCause, or nil.
+func (pd *ProblemDetails) Unwrap() error {
+	return pd.cause
+}
+
+// WithCode sets pd.Code to code and returns pd. If code is "", pd.Code is left as is.
+//
+// The code is a short, stable, machine-readable identifier of the error (e.g. "USER_NOT_FOUND") that clients can switch on without parsing URIs.
+// Unlike the type, which identifies (and may document) the problem type, the code can be more granular and follows the API's own taxonomy.
+// Unlike the status, which is the HTTP status code of the response, it's independent of the transport.
+func (pd *ProblemDetails) WithCode(code string) *ProblemDetails {
+	if code != "" {
+		pd.Code = code
+	}
+	return pd
+}
+
+// WithLink appends a link to href with the relation type rel (e.g. "help" or "describedby") to pd.Links and returns pd.
+func (pd *ProblemDetails) WithLink(rel string, href string) *ProblemDetails {
+	pd.Links = append(pd.Links, Link{Href: href, Rel: rel})
+	return pd
+}
+
+// WithInvalidParams sets pd.InvalidParams to params and returns pd. If params is empty, the "invalid-params" member is omitted.
+func (pd *ProblemDetails) WithInvalidParams(params []InvalidParam) *ProblemDetails {
+	pd.InvalidParams = params
+	return pd
+}
+
+// AddInvalidParam appends an invalid parameter with the given name and the reason it's invalid to pd.InvalidParams and returns pd.
+func (pd *ProblemDetails) AddInvalidParam(name string, reason string) *ProblemDetails {
+	pd.InvalidParams = append(pd.InvalidParams, InvalidParam{Name: name, Reason: reason})
+	return pd
+}
+
+// RangeExtensions calls fn for each extension member of pd, in an unspecified order, until fn returns false.
+// Like the serializers, it skips the members whose names collide with the fields of ProblemDetails (e.g. "status").
+// fn must not modify pd.Extensions (e.g. with Merge) during the iteration.
+func (pd *ProblemDetails) RangeExtensions(fn func(key string, value any) bool) {
+	for key, value := range pd.Extensions {
+		if _, ok := reservedMembers[key]; ok {
+			continue
+		}
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// RangeExtensionsSorted is like RangeExtensions, but calls fn in order of the member names.
+func (pd *ProblemDetails) RangeExtensionsSorted(fn func(key string, value any) bool) {
+	for _, key := range slices.Sorted(maps.Keys(pd.Extensions)) {
+		if _, ok := reservedMembers[key]; ok {
+			continue
+		}
+		if !fn(key, pd.Extensions[key]) {
+			return
+		}
+	}
+}
+
+// Reset zeroes all fields of pd so it can be reused, keeping the capacity of the Errors slice and the Extensions map.
+func (pd *ProblemDetails) Reset() {
+	clear(pd.Errors)
+	errors := pd.Errors[:0]
+	extensions := pd.Extensions
+	clear(extensions)
+	*pd = ProblemDetails{Errors: errors, Extensions: extensions}
+}
+
+// clone returns a copy of pd whose Extensions, Errors, InvalidParams and Links can be modified without affecting pd.
+// Extension values themselves are not copied.
+func (pd *ProblemDetails) clone() *ProblemDetails {
+	cp := *pd
+	cp.Extensions = maps.Clone(pd.Extensions)
+	cp.Errors = slices.Clone(pd.Errors)
+	cp.InvalidParams = slices.Clone(pd.InvalidParams)
+	cp.Links = slices.Clone(pd.Links)
+	return &cp
+}
+
+// Merge sets the empty fields of pd to the ones of other, and adds the extension members of other that pd doesn't have, so pd takes precedence.
+// Slices and maps are copied, so other isn't modified by later changes to pd. If other is nil this is a no-op.
+//
+// This is useful for layering, e.g. merging a base problem details object shared by many responses into a request-specific one.
+func (pd *ProblemDetails) Merge(other *ProblemDetails) {
+	if other == nil {
+		return
+	}
+
+	mergeField(&pd.Schema, other.Schema)
+	mergeField(&pd.Type, other.Type)
+	mergeField(&pd.Status, other.Status)
+	mergeField(&pd.Title, other.Title)
+	mergeField(&pd.Detail, other.Detail)
+	mergeField(&pd.Instance, other.Instance)
+	mergeField(&pd.RequestId, other.RequestId)
+	mergeField(&pd.TraceId, other.TraceId)
+	mergeField(&pd.Code, other.Code)
+	if len(pd.Errors) == 0 && len(other.Errors) > 0 {
+		pd.Errors = slices.Clone(other.Errors)
+	}
+	if len(pd.Links) == 0 && len(other.Links) > 0 {
+		pd.Links = slices.Clone(other.Links)
+	}
+	if len(pd.InvalidParams) == 0 && len(other.InvalidParams) > 0 {
+		pd.InvalidParams = slices.Clone(other.InvalidParams)
+	}
+	if pd.cause == nil {
+		pd.cause = other.cause
+	}
+
+	for name, value := range other.Extensions {
+		if _, ok := pd.Extensions[name]; ok {
+			continue
+		}
+		if pd.Extensions == nil {
+			pd.Extensions = make(map[string]any, len(other.Extensions))
+		}
+		pd.Extensions[name] = value
+	}
+}
+
+func mergeField[T comparable](field *T, other T) {
+	var zero T
+	if *field == zero {
+		*field = other
+	}
 }
 
 type Error struct {
@@ -34,6 +218,46 @@ type Error struct {
 	Code      string `json:"code,omitempty"`      // A string containing additional provider specific codes to identify the error context.
 }
 
+// InvalidParam is an invalid request parameter, e.g. a body property that failed validation.
+type InvalidParam struct {
+	Name   string `json:"name"`   // The name of the parameter.
+	Reason string `json:"reason"` // Why the parameter is invalid.
+}
+
+// Link is a RFC 8288 web link.
+type Link struct {
+	Href  string `json:"href"`            // The target URI of the link.
+	Rel   string `json:"rel"`             // The relation type of the link, e.g. "help" or "describedby".
+	Title string `json:"title,omitempty"` // A human-readable label for the link.
+}
+
+// String returns the link in the format of a Link header value, e.g. `<https://example.com/docs>; rel="help"; title="Docs"`.
+// Characters of the href that could end the target or split the header value (e.g. ">" and ",") are percent-encoded.
+func (l Link) String() string {
+	s := "<" + escapeLinkHref(l.Href) + `>; rel="` + quotedStringReplacer.Replace(l.Rel) + `"`
+	if l.Title != "" {
+		s += `; title="` + quotedStringReplacer.Replace(l.Title) + `"`
+	}
+	return s
+}
+
+var quotedStringReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// escapeLinkHref percent-encodes the characters of href that aren't allowed in a URI reference and could break out of the
+// angle brackets of a Link header value or split it (e.g. "<", ">", "," and whitespace), leaving the rest as is.
+func escapeLinkHref(href string) string {
+	var b strings.Builder
+	for i := 0; i < len(href); i++ {
+		c := href[i]
+		if c <= ' ' || c == 0x7f || strings.IndexByte(`<>",`, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
 // pointer: A JSON Pointer to a specific request body property that is the source of error.
 //
 // detail: A granular description on the specific error related to the body property.
@@ -93,13 +317,85 @@ func Write(w http.ResponseWriter, r *http.Request, status int, detail string, co
 	Default().Write(w, r, status, detail, code, errors...)
 }
 
+// Writes pd as a problem details http response using the default problem details writer. See Writer.WriteProblem.
+func WriteProblem(w http.ResponseWriter, r *http.Request, pd *ProblemDetails) {
+	Default().WriteProblem(w, r, pd)
+}
+
+// Writes err as a problem details http response using the default problem details writer. See Writer.WriteError.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	Default().WriteError(w, r, err)
+}
+
+// Writes a HTTP 415 (Unsupported Media Type) problem details response using the default problem details writer.
+//
+// supported: The media types accepted by the endpoint. They're written to the "supportedMediaTypes" extension member and,
+// for POST and PATCH requests, to the Accept-Post and Accept-Patch headers respectively. If empty, both are omitted.
+//
+// detail: A human-readable explanation specific to this occurrence of the problem.
+func WriteUnsupportedMediaType(w http.ResponseWriter, r *http.Request, supported []string, detail string) {
+	Default().WriteUnsupportedMediaType(w, r, supported, detail)
+}
+
+// Writes a HTTP 413 (Request Entity Too Large) problem details response using the default problem details writer. See Writer.WritePayloadTooLarge.
+func WritePayloadTooLarge(w http.ResponseWriter, r *http.Request, limitBytes int64, detail string) {
+	Default().WritePayloadTooLarge(w, r, limitBytes, detail)
+}
+
+// Writes a HTTP 413 (Request Entity Too Large) problem details response, e.g. for requests whose body exceeds the limit of http.MaxBytesReader.
+// WriteError writes the same response for errors that are or wrap a *http.MaxBytesError.
+//
+// limitBytes: The maximum size of the request body in bytes. It's written to the "maxBytes" extension member. If < 0, it's omitted.
+//
+// detail: A human-readable explanation specific to this occurrence of the problem.
+//
+// If the condition is temporary, set the Retry-After header before calling this, as per RFC 9110 section 15.5.14.
+func (pdw *Writer) WritePayloadTooLarge(w http.ResponseWriter, r *http.Request, limitBytes int64, detail string) {
+	pdCtx := FromContext(r.Context())
+	pd := pdw.newProblemDetails(r, pdCtx, http.StatusRequestEntityTooLarge, detail, "", nil)
+	if limitBytes >= 0 {
+		pd.Extensions = map[string]any{"maxBytes": limitBytes}
+	}
+	pdw.writeProblemDetails(w, r, pdCtx, pd, encoding{format: FormatJSON})
+}
+
+// Writes a cacheable problem details http response with an ETag using the default problem details writer. See Writer.WriteCacheable.
+func WriteCacheable(w http.ResponseWriter, r *http.Request, status int, etag string, detail string) {
+	Default().WriteCacheable(w, r, status, etag, detail)
+}
+
 type Writer struct {
 	GetRequestID         func(*http.Request) string // A function that gets the request ID to write in the problem details response. If nil or if the returned value is "", the request ID field will be omitted.
 	GetTraceID           func(*http.Request) string // A function that gets the trace ID to write in the problem details response. If nil or if the returned value is "", the trace ID field will be omitted.
+	GetInstance          func(*http.Request) string // A function that gets the instance of problem details responses that don't have one (including from SetInstance), e.g. the matched route pattern. If nil or if the returned value is "", the instance field will be omitted.
 	ProblemDetailsSchema string                     // The json schema for the problem details response. For example, https://www.rfc-editor.org/rfc/rfc9457.html#name-json-schema-for-http-proble. If "" the $schema field will be omitted.
+	StrictCharset        bool                       // If true, a HTTP 406 (Not Acceptable) problem details response is written instead when the request's Accept-Charset header excludes UTF-8. Otherwise responses are always UTF-8.
+	StrictAccept         bool                       // If true, a HTTP 406 (Not Acceptable) problem details response (itself JSON) is written instead when the request's Accept header excludes the media type of the response's format (e.g. application/problem+json, see Config.Format), the one of its suffix (e.g. application/json), and for XML text/xml. Otherwise the Accept header is disregarded, as RFC 9110 section 12.5.1 allows, and responses are always in that format.
+	LinkHeader           bool                       // If true, the links of the problem details response (see ProblemDetails.Links) are also written to the Link header.
+	BaseURI              string                     // If not "", relative type URI references (e.g. "/probs/out-of-credit") are resolved against it into absolute URIs when writing. Absolute types (including "about:blank") are left as is. It must be an absolute URI, otherwise it's ignored.
+	AbsoluteInstance     bool                       // If true, relative instance URI references (e.g. "/orders/123" from SetInstance) are resolved against the request's URL (see RequestURL) into absolute URIs when writing.
+	TrustForwarded       bool                       // If true, the request URL used for AbsoluteInstance honors the Forwarded and X-Forwarded-Proto/X-Forwarded-Host headers. This is security-sensitive: only enable it behind a reverse proxy that sets or overwrites them, since clients can spoof them.
+	RedactServerErrors   bool                       // If true, the detail field is omitted from the response body of 5xx problem details responses (e.g. in production), to avoid disclosing internal information. Context.Details still returns the original detail.
+	RevealServerErrors   func(*http.Request) bool   // If not nil and it returns true for a request, the detail of its 5xx problem details responses isn't omitted even if RedactServerErrors is true, e.g. for authenticated internal calls during incident response. It's called at write time. This is security-sensitive: it must only return true for trusted requests, e.g. after verifying a credential, never based on the mere presence of a header.
+	MaxTitleLen          int                        // If > 0, titles longer than this many characters are truncated to it, ending with an ellipsis ("…"), to keep reflected input from bloating responses.
+	MaxDetailLen         int                        // If > 0, details longer than this many characters are truncated to it, ending with an ellipsis ("…").
+	CacheControl         string                     // The Cache-Control header of problem details responses, unless the header is already set (e.g. for an intentionally cacheable error). If "", "no-store" is used, so that intermediaries don't serve stale error responses.
+
+	// Extension members added to every problem details response, by type URI (after resolving it against BaseURI), by status code,
+	// and for all responses. Extension members that are already set take precedence over the ones for the type, which take precedence
+	// over the ones for the status, which take precedence over the ones for all responses. Members whose names collide with the fields
+	// of ProblemDetails are ignored, as with any extension members.
+	// The maps must not be modified while the writer is in use.
+	TypeExtensions    map[string]map[string]any
+	StatusExtensions  map[int]map[string]any
+	DefaultExtensions map[string]any
 }
 
 // Writes a problem details http response.
+// The response is serialized as UTF-8 JSON with the Content-Type "application/problem+json; charset=utf-8", so clients accepting
+// "application/json" (or nothing at all) can read it as plain JSON. The request's Accept header is disregarded unless pdw.StrictAccept
+// is true, in which case requests that accept neither get a HTTP 406 (Not Acceptable) problem details response instead.
+// To serialize responses in another format (FormatXML or FormatCBOR), write them through a Config with that format (see WithFormat).
 //
 // detail: A human-readable explanation specific to this occurrence of the problem.
 //
@@ -108,82 +404,431 @@ type Writer struct {
 // errors: [Optional] An array of error details to accompany a problem details response.
 //
 // Returns error if there were invalid arguments, but writes the problem details response either way.
+//
+// If the request context contains a `*problemdetails.Context`, the type and instance set with SetType and SetInstance are used.
+// A type set with SetType takes precedence over the type derived from the status code.
 func (pdw *Writer) Write(w http.ResponseWriter, r *http.Request, status int, detail string, code string, errors ...Error) {
-	var typeUri string
+	pdw.write(w, r, FromContext(r.Context()), encoding{format: FormatJSON}, status, detail, code, errors)
+}
+
+func (pdw *Writer) write(w http.ResponseWriter, r *http.Request, pdCtx *Context, enc encoding, status int, detail string, code string, errors []Error) {
+	pd := pdw.newProblemDetails(r, pdCtx, status, detail, code, errors)
+	pdw.writeProblemDetails(w, r, pdCtx, pd, enc)
+}
+
+// Writes pd as a problem details http response, with pd.Status as the status code.
+//
+// The empty fields of pd that have a default are set to it first, the same way as with Write. Fields that are already set are left as is,
+// so for example pd.Type takes precedence over a type set with SetType, which takes precedence over the type derived from the status code.
+func (pdw *Writer) WriteProblem(w http.ResponseWriter, r *http.Request, pd *ProblemDetails) {
+	pdw.writeProblem(w, r, FromContext(r.Context()), encoding{format: FormatJSON}, pd)
+}
+
+// Writes err as a problem details http response.
+//
+// If err is or wraps a *ProblemDetails, it's written as with WriteProblem. Otherwise a HTTP 500 (Internal Server Error) problem details
+// response without a detail is written, so that the error message doesn't leak to the client, with err as its cause (see ProblemDetails.WithCause)
+// so it's still available server-side, e.g. to request loggers through the `problemdetails.Context`.
+// Errors that are or wrap a *http.MaxBytesError, and joined errors (e.g. from errors.Join), are handled as described in FromError.
+func (pdw *Writer) WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	pdw.writeError(w, r, FromContext(r.Context()), encoding{format: FormatJSON}, err)
+}
+
+func (pdw *Writer) writeError(w http.ResponseWriter, r *http.Request, pdCtx *Context, enc encoding, err error) {
+	pdw.writeProblem(w, r, pdCtx, enc, FromError(err))
+}
+
+// FromError returns the problem details object that WriteError writes for err.
+//
+// If err is or wraps a *ProblemDetails, a copy of it is returned. If it is or wraps a *http.MaxBytesError, a HTTP 413 (Request Entity Too Large) problem details
+// object with its limit in the "maxBytes" extension member (as with WritePayloadTooLarge) is returned, with err as its cause.
+// Otherwise a HTTP 500 (Internal Server Error) problem details object without a detail is returned, with err as its cause.
+//
+// If err is a joined error (i.e. it implements `Unwrap() []error`, like the errors returned by errors.Join), each joined error that is or wraps
+// a *ProblemDetails or a *http.MaxBytesError is mapped to a problem details object as above, and the other joined errors are ignored. If only one problem details object is found, it's returned.
+// If all the problem details objects have the same status, a copy of the first one is returned, with the errors (see ProblemDetails.Errors)
+// of all of them. Otherwise an aggregate problem details object is returned, with err as its cause and with the errors of all of them, or
+// an error with the detail (or title) and code of those without errors. Its status is HTTP 400 (Bad Request) if all of them are client errors
+// (4xx), otherwise HTTP 500 (Internal Server Error).
+func FromError(err error) *ProblemDetails {
+	if pd := fromJoinedError(err); pd != nil {
+		return pd
+	}
+	if pd := fromMappedError(err); pd != nil {
+		return pd
+	}
+	return (&ProblemDetails{Status: http.StatusInternalServerError}).WithCause(err)
+}
+
+// fromMappedError returns the problem details object for err if it is or wraps a *ProblemDetails or a *http.MaxBytesError, otherwise nil.
+func fromMappedError(err error) *ProblemDetails {
+	var pd *ProblemDetails
+	if errors.As(err, &pd) {
+		return pd.clone()
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		pd := &ProblemDetails{Status: http.StatusRequestEntityTooLarge, Extensions: map[string]any{"maxBytes": maxBytesErr.Limit}}
+		return pd.WithCause(err)
+	}
+
+	return nil
+}
+
+// fromJoinedError returns the problem details object for err if it's a joined error that contains any, otherwise nil. See FromError.
+func fromJoinedError(err error) *ProblemDetails {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return nil
+	}
+
+	var pds []*ProblemDetails
+	for _, e := range joined.Unwrap() {
+		pd := fromJoinedError(e)
+		if pd == nil {
+			pd = fromMappedError(e)
+		}
+		if pd != nil {
+			pds = append(pds, pd)
+		}
+	}
+
+	switch len(pds) {
+	case 0:
+		return nil
+	case 1:
+		return pds[0]
+	}
+
+	homogeneous, clientErrors := true, true
+	var errs []Error
+	for _, pd := range pds {
+		homogeneous = homogeneous && pd.Status == pds[0].Status
+		clientErrors = clientErrors && pd.Status >= 400 && pd.Status < 500
+		errs = append(errs, pd.Errors...)
+	}
+
+	if homogeneous {
+		collapsed := pds[0].clone()
+		collapsed.Errors = errs
+		return collapsed
+	}
+
+	errs = nil
+	for _, pd := range pds {
+		if len(pd.Errors) > 0 {
+			errs = append(errs, pd.Errors...)
+		} else {
+			errs = append(errs, Error{Detail: cmp.Or(pd.Detail, pd.Title, StatusText(pd.Status)), Code: pd.Code})
+		}
+	}
+
+	status := http.StatusInternalServerError
+	if clientErrors {
+		status = http.StatusBadRequest
+	}
+	return (&ProblemDetails{Status: status, Errors: errs}).WithCause(err)
+}
+
+func (pdw *Writer) writeProblem(w http.ResponseWriter, r *http.Request, pdCtx *Context, enc encoding, pd *ProblemDetails) {
+	pdw.setDefaults(r, pdCtx, pd)
+	pdw.writeProblemDetails(w, r, pdCtx, pd, enc)
+}
+
+// Writes a HTTP 415 (Unsupported Media Type) problem details response.
+//
+// supported: The media types accepted by the endpoint. They're written to the "supportedMediaTypes" extension member and,
+// for POST and PATCH requests, to the Accept-Post and Accept-Patch headers respectively. If empty, both are omitted.
+//
+// detail: A human-readable explanation specific to this occurrence of the problem.
+func (pdw *Writer) WriteUnsupportedMediaType(w http.ResponseWriter, r *http.Request, supported []string, detail string) {
+	pdCtx := FromContext(r.Context())
+	pd := pdw.newProblemDetails(r, pdCtx, http.StatusUnsupportedMediaType, detail, "", nil)
+
+	if len(supported) > 0 {
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Accept-Post", strings.Join(supported, ", "))
+		case http.MethodPatch:
+			w.Header().Set("Accept-Patch", strings.Join(supported, ", "))
+		}
+		pd.Extensions = map[string]any{"supportedMediaTypes": supported}
+	}
+
+	pdw.writeProblemDetails(w, r, pdCtx, pd, encoding{format: FormatJSON})
+}
+
+// Writes a cacheable problem details http response with an ETag, for the rare errors whose responses are stable (e.g. HTTP 451).
+// Most problem details responses are not cacheable and should be written with Write instead.
+//
+// etag: The entity tag of the response, e.g. `"v1"` or `W/"v1"`. It's quoted if it isn't already.
+//
+// detail: A human-readable explanation specific to this occurrence of the problem.
+//
+// For GET and HEAD requests with an If-None-Match header matching etag, only a HTTP 304 (Not Modified) response with the ETag is written.
+//
+// Unless the Cache-Control header is already set, it's set to "no-cache", i.e. the response may be stored but must be revalidated.
+func (pdw *Writer) WriteCacheable(w http.ResponseWriter, r *http.Request, status int, etag string, detail string) {
+	if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	w.Header().Set("ETag", etag)
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Values("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	pdw.Write(w, r, status, detail, "")
+}
+
+// etagMatches reports whether any of the given If-None-Match header values match etag, using the weak comparison.
+func etagMatches(ifNoneMatch []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range ifNoneMatch {
+		for tag := range strings.SplitSeq(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newProblemDetails creates the problem details object for the given status, using the type and instance set in pdCtx if it's not nil.
+func (pdw *Writer) newProblemDetails(r *http.Request, pdCtx *Context, status int, detail string, code string, errors []Error) *ProblemDetails {
+	pd := &ProblemDetails{
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: errors,
+	}
+	pdw.setDefaults(r, pdCtx, pd)
+	return pd
+}
+
+// setDefaults sets the empty fields of pd that have a default value: the schema, type, title, instance, request ID and trace ID.
+// The type and instance set in pdCtx (if it's not nil) take precedence over the defaults derived from the status and pdw.GetInstance.
+func (pdw *Writer) setDefaults(r *http.Request, pdCtx *Context, pd *ProblemDetails) {
+	if pd.Schema == "" {
+		pd.Schema = pdw.ProblemDetailsSchema
+	}
+	if pd.Type == "" {
+		if pdCtx != nil && pdCtx.typeUri != "" {
+			pd.Type = pdCtx.typeUri
+		} else {
+			pd.Type = defaultTypeUri(pd.Status)
+		}
+	}
+	if pd.Title == "" {
+		pd.Title = StatusText(pd.Status)
+	}
+	if pd.Instance == "" && pdCtx != nil {
+		pd.Instance = pdCtx.instance
+	}
+	if pd.Instance == "" && pdw.GetInstance != nil {
+		pd.Instance = pdw.GetInstance(r)
+	}
+
+	if pd.RequestId == "" && pdw.GetRequestID != nil {
+		pd.RequestId = pdw.GetRequestID(r)
+	}
+	if pd.TraceId == "" && pdw.GetTraceID != nil {
+		pd.TraceId = pdw.GetTraceID(r)
+	}
+}
+
+var (
+	statusTextsMu sync.RWMutex
+	statusTexts   = map[int]string{}
+)
+
+// RegisterStatusText registers text as the reason phrase for the status code, which is used as the default title of problem details with that status.
+// This is useful for non-standard status codes which http.StatusText doesn't know, e.g. `RegisterStatusText(499, "Client Closed Request")`,
+// and can also be used to override the phrases of standard status codes.
+//
+// It's safe to call concurrently, but is meant to be called during initialization.
+func RegisterStatusText(code int, text string) {
+	statusTextsMu.Lock()
+	defer statusTextsMu.Unlock()
+	statusTexts[code] = text
+}
+
+// StatusText returns the reason phrase for the status code registered with RegisterStatusText, or http.StatusText(code) if there is none.
+func StatusText(code int) string {
+	statusTextsMu.RLock()
+	text, ok := statusTexts[code]
+	statusTextsMu.RUnlock()
+	if ok {
+		return text
+	}
+	return http.StatusText(code)
+}
+
+// defaultTypeUri returns the type URI used for problem details responses with the given status.
+func defaultTypeUri(status int) string {
 	switch status {
 	case http.StatusNotFound:
-		typeUri = "https://problems-registry.smartbear.com/not-found"
+		return "https://problems-registry.smartbear.com/not-found"
 	case http.StatusUnauthorized:
-		typeUri = "https://problems-registry.smartbear.com/unauthorized"
+		return "https://problems-registry.smartbear.com/unauthorized"
 	case http.StatusForbidden:
-		typeUri = "https://problems-registry.smartbear.com/forbidden"
+		return "https://problems-registry.smartbear.com/forbidden"
 	case http.StatusBadRequest:
-		typeUri = "https://problems-registry.smartbear.com/bad-request"
+		return "https://problems-registry.smartbear.com/bad-request"
 	case http.StatusServiceUnavailable:
-		typeUri = "https://problems-registry.smartbear.com/service-unavailable"
+		return "https://problems-registry.smartbear.com/service-unavailable"
 	case http.StatusInternalServerError:
-		typeUri = "https://problems-registry.smartbear.com/server-error"
+		return "https://problems-registry.smartbear.com/server-error"
 	default:
-		typeUri = "about:blank"
+		return "about:blank"
 	}
+}
 
-	pd := pdw.newProblemDetails(
-		r,
-		typeUri,
-		status,
-		http.StatusText(status),
-		detail,
-		code,
-		errors,
-	)
+// addDefaultExtensions adds the extension members for pd's type and status, and for all responses, to pd.Extensions unless they're already set.
+// If any are added, pd.Extensions is replaced with a copy first, so the original map isn't modified.
+func (pdw *Writer) addDefaultExtensions(pd *ProblemDetails) {
+	copied := false
+	for _, defaults := range [...]map[string]any{pdw.TypeExtensions[pd.Type], pdw.StatusExtensions[pd.Status], pdw.DefaultExtensions} {
+		for name, value := range defaults {
+			if _, ok := pd.Extensions[name]; ok {
+				continue
+			}
+			if !copied {
+				// pd.Extensions may be shared, e.g. by problems built from the same map, so it's copied before it's modified.
+				extensions := make(map[string]any, len(pd.Extensions)+len(defaults))
+				maps.Copy(extensions, pd.Extensions)
+				pd.Extensions = extensions
+				copied = true
+			}
+			pd.Extensions[name] = value
+		}
+	}
+}
 
-	err := marshalJSON(w, pd)
+// typeBase returns the base that relative type URIs are resolved against for r: the base of the innermost MountWithTypeBase
+// (resolved against pdw.BaseURI), or pdw.BaseURI if r isn't within one.
+func (pdw *Writer) typeBase(r *http.Request) string {
+	base, ok := r.Context().Value(typeBaseCtxKey).(string)
+	if !ok {
+		return pdw.BaseURI
+	}
+	if pdw.BaseURI != "" {
+		return resolveTypeUri(pdw.BaseURI, base)
+	}
+	return base
+}
 
-	pdCtx, ok := r.Context().Value(CtxKey).(*Context)
-	if ok {
-		pdCtx.pd = pd
-		pdCtx.respWriteErr = err
+// resolveTypeUri resolves typeUri (or another URI reference, e.g. an instance) against base if it's a relative URI reference.
+// If typeUri is absolute, or either of them is invalid or the result isn't absolute, typeUri is returned as is.
+func resolveTypeUri(base string, typeUri string) string {
+	ref, err := url.Parse(typeUri)
+	if err != nil || ref.IsAbs() {
+		return typeUri
 	}
+	baseUrl, err := url.Parse(base)
+	if err != nil || !baseUrl.IsAbs() {
+		return typeUri
+	}
+	return baseUrl.ResolveReference(ref).String()
 }
 
-func (pdw *Writer) newProblemDetails(r *http.Request, typeUri string, status int, title string, detail string, code string, errors []Error) *ProblemDetails {
-	requestId := ""
-	if pdw.GetRequestID != nil {
-		requestId = pdw.GetRequestID(r)
+// writeProblemDetails writes pd to w serialized with enc, and records it (and the write error, if any) in pdCtx if it's not nil.
+// If pdw.StrictAccept is true and the request doesn't accept the format of enc (in which case the response is JSON), or pdw.StrictCharset is true and the request doesn't accept UTF-8,
+// a HTTP 406 (Not Acceptable) problem details response is written instead.
+func (pdw *Writer) writeProblemDetails(w http.ResponseWriter, r *http.Request, pdCtx *Context, pd *ProblemDetails, enc encoding) {
+	if pdw.StrictAccept && !acceptsFormat(r.Header.Values("Accept"), enc.format) {
+		mediaTypes := acceptableMediaTypes(enc.format)
+		served := mediaTypes[0]
+		if last := len(mediaTypes) - 1; last > 0 {
+			served = strings.Join(mediaTypes[:last], ", ") + " or " + mediaTypes[last]
+		}
+		detail := fmt.Sprintf("The response can only be served as %s, which the Accept header excludes.", served)
+		pd = pdw.newProblemDetails(r, nil, http.StatusNotAcceptable, detail, "", nil)
+		enc = encoding{format: FormatJSON} // The 406 response is always JSON, the most widely understood format.
+	} else if pdw.StrictCharset && !acceptsUTF8(r.Header.Values("Accept-Charset")) {
+		pd = pdw.newProblemDetails(r, nil, http.StatusNotAcceptable, "The response can only be encoded in UTF-8, which the Accept-Charset header excludes.", "", nil)
+	}
+
+	if base := pdw.typeBase(r); base != "" {
+		pd.Type = resolveTypeUri(base, pd.Type)
 	}
-	traceId := ""
-	if pdw.GetTraceID != nil {
-		traceId = pdw.GetTraceID(r)
+	if pdw.AbsoluteInstance && pd.Instance != "" {
+		pd.Instance = resolveTypeUri(RequestURL(r, pdw.TrustForwarded).String(), pd.Instance)
 	}
 
-	pd := &ProblemDetails{
-		Schema: pdw.ProblemDetailsSchema,
-		Type:   typeUri,
-		Status: status,
-		Title:  title,
-		Detail: detail,
+	pdw.addDefaultExtensions(pd)
+	runProblemHooks(r, pd)
 
-		RequestId: requestId,
-		TraceId:   traceId,
+	// Titles are single-line summaries, so control characters (e.g. newlines in reflected input that could break log parsers) are stripped from them.
+	pd.Title = truncate(stripControl(pd.Title), pdw.MaxTitleLen)
+	pd.Detail = truncate(pd.Detail, pdw.MaxDetailLen)
 
-		Code:   code,
-		Errors: errors,
+	if pdw.LinkHeader {
+		for _, link := range pd.Links {
+			w.Header().Add("Link", link.String())
+		}
 	}
 
-	return pd
+	if w.Header().Get("Cache-Control") == "" {
+		cacheControl := pdw.CacheControl
+		if cacheControl == "" {
+			cacheControl = "no-store"
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+
+	body := pd
+	if pdw.RedactServerErrors && pd.Status >= 500 && pd.Detail != "" && (pdw.RevealServerErrors == nil || !pdw.RevealServerErrors(r)) {
+		redacted := *pd
+		redacted.Detail = ""
+		body = &redacted
+	}
+
+	err := writeBody(w, body, enc)
+
+	if pdCtx != nil {
+		pdCtx.pd = pd
+		pdCtx.respWriteErr = err
+	}
+}
+
+// stripControl removes the control characters from s, replacing the whitespace ones (e.g. newlines and tabs) with spaces.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsControl(r) {
+			return r
+		}
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return -1
+	}, s)
+}
+
+// truncate truncates s to maxLen characters, ending it with an ellipsis, if it's longer. If maxLen <= 0, s is returned as is.
+func truncate(s string, maxLen int) string {
+	if maxLen <= 0 || utf8.RuneCountInString(s) <= maxLen {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:maxLen-1]) + "…"
 }
 
-func marshalJSON(w http.ResponseWriter, pd *ProblemDetails) error {
-	buf := &bytes.Buffer{}
-	enc := json.NewEncoder(buf)
-	enc.SetEscapeHTML(true)
-	if err := enc.Encode(pd); err != nil {
+func writeBody(w http.ResponseWriter, pd *ProblemDetails, enc encoding) error {
+	body, contentType, err := enc.marshal(pd)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
-	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(pd.Status)
-	_, err := w.Write(buf.Bytes())
+	_, err = w.Write(body)
 	return err
 }
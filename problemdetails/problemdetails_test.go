@@ -0,0 +1,674 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteAccept(t *testing.T) {
+	for _, accept := range []string{
+		"",
+		"*/*",
+		"application/json",
+		"application/problem+json",
+		"application/json;q=0.9, application/problem+json",
+		"application/xml",
+		"text/xml",
+		"text/html",
+	} {
+		t.Run(accept, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			if accept != "" {
+				r.Header.Set("Accept", accept)
+			}
+
+			Write(w, r, http.StatusNotFound, "", "")
+
+			assertEqual(t, w.Code, http.StatusNotFound)
+			assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+
+			pd := &ProblemDetails{}
+			if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, pd.Status, http.StatusNotFound)
+		})
+	}
+}
+
+func TestProblemDetailsReset(t *testing.T) {
+	pd := &ProblemDetails{
+		Schema:     "https://example.com/schema.json",
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     http.StatusForbidden,
+		Title:      "You do not have enough credit.",
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		RequestId:  "req",
+		TraceId:    "trace",
+		Code:       "OUT_OF_CREDIT",
+		Errors:     []Error{NewGenericError("foo", "bar")},
+		Extensions: map[string]any{"balance": 30},
+	}
+	errorsCap := cap(pd.Errors)
+	extensions := pd.Extensions
+
+	pd.Reset()
+
+	assertEqual(t, cap(pd.Errors), errorsCap)
+	assertEqual(t, len(extensions), 0)
+	pd.Extensions["foo"] = "bar"
+	assertEqual(t, extensions["foo"], "bar")
+	pd.Reset()
+	b, err := json.Marshal(pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), `{"type":"about:blank","status":0,"title":""}`)
+}
+
+func TestProblemDetailsMerge(t *testing.T) {
+	base := &ProblemDetails{
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     http.StatusForbidden,
+		Title:      "You do not have enough credit.",
+		Detail:     "Base detail.",
+		Errors:     []Error{NewGenericError("base", "")},
+		Extensions: map[string]any{"docs": "https://example.com/docs/credit", "balance": 0},
+	}
+
+	pd := &ProblemDetails{
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30},
+	}
+	pd.Merge(base)
+
+	assertEqual(t, pd, &ProblemDetails{
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     http.StatusForbidden,
+		Title:      "You do not have enough credit.",
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Errors:     []Error{NewGenericError("base", "")},
+		Extensions: map[string]any{"docs": "https://example.com/docs/credit", "balance": 30},
+	})
+
+	pd.Errors[0].Detail = "changed"
+	assertEqual(t, base.Errors[0].Detail, "base")
+
+	disjoint := &ProblemDetails{Code: "OUT_OF_CREDIT"}
+	disjoint.Merge(&ProblemDetails{Status: http.StatusForbidden, Extensions: map[string]any{"docs": "x"}})
+	assertEqual(t, disjoint, &ProblemDetails{Status: http.StatusForbidden, Code: "OUT_OF_CREDIT", Extensions: map[string]any{"docs": "x"}})
+
+	disjoint.Merge(nil)
+	assertEqual(t, disjoint.Status, http.StatusForbidden)
+}
+
+func TestProblemDetailsRangeExtensions(t *testing.T) {
+	pd := &ProblemDetails{Extensions: map[string]any{"c": 3, "a": 1, "d": 4, "b": 2, "status": 500}}
+
+	var keys []string
+	var sum int
+	pd.RangeExtensionsSorted(func(key string, value any) bool {
+		keys = append(keys, key)
+		sum += value.(int)
+		return true
+	})
+	assertEqual(t, keys, []string{"a", "b", "c", "d"})
+	assertEqual(t, sum, 10)
+
+	keys = nil
+	pd.RangeExtensionsSorted(func(key string, value any) bool {
+		keys = append(keys, key)
+		return key != "b"
+	})
+	assertEqual(t, keys, []string{"a", "b"})
+
+	seen := map[string]any{}
+	pd.RangeExtensions(func(key string, value any) bool {
+		seen[key] = value
+		return true
+	})
+	assertEqual(t, seen, map[string]any{"a": 1, "b": 2, "c": 3, "d": 4})
+
+	(&ProblemDetails{}).RangeExtensionsSorted(func(key string, value any) bool {
+		t.Fatal("expected no extension members")
+		return true
+	})
+}
+
+func TestProblem(t *testing.T) {
+	assertEqual(t, Problem(http.StatusNotFound), &ProblemDetails{Status: http.StatusNotFound, Title: "Not Found"})
+
+	RegisterStatusText(499, "Client Closed Request")
+	defer func() {
+		statusTextsMu.Lock()
+		delete(statusTexts, 499)
+		statusTextsMu.Unlock()
+	}()
+	assertEqual(t, Problem(499).Title, "Client Closed Request")
+
+	pd := Problemf(http.StatusNotFound, "No user with ID %d.", 12345)
+	assertEqual(t, pd.Title, "Not Found")
+	assertEqual(t, pd.Detail, "No user with ID 12345.")
+	assertEqual(t, pd.Unwrap(), nil)
+
+	pd = Problemf(http.StatusNotFound, "Loading user %d: %w", 12345, fs.ErrNotExist)
+	assertEqual(t, pd.Detail, "Loading user 12345: file does not exist")
+	assertEqual(t, errors.Is(pd, fs.ErrNotExist), true)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	WriteError(w, r, pd)
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","detail":"Loading user 12345: file does not exist"}`+"\n")
+}
+
+func TestProblemDetailsCause(t *testing.T) {
+	cause := &fs.PathError{Op: "open", Path: "/etc/secret", Err: fs.ErrNotExist}
+	var err error = (&ProblemDetails{Status: http.StatusNotFound, Title: "Not Found"}).WithCause(cause)
+
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatal("errors.As should reach the cause")
+	}
+	assertEqual(t, pathErr, cause)
+	assertEqual(t, errors.Is(err, fs.ErrNotExist), true)
+
+	var pd *ProblemDetails
+	if !errors.As(err, &pd) {
+		t.Fatal("errors.As should match *ProblemDetails")
+	}
+	b, err := json.Marshal(pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(b), `{"type":"about:blank","status":404,"title":"Not Found"}`)
+}
+
+func TestWriteUnsupportedMediaType(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", nil)
+
+	WriteUnsupportedMediaType(w, r, []string{"application/json", "application/xml"}, "Unsupported Content-Type.")
+
+	assertEqual(t, w.Code, http.StatusUnsupportedMediaType)
+	assertEqual(t, w.Header().Get("Accept-Post"), "application/json, application/xml")
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":415,"title":"Unsupported Media Type","detail":"Unsupported Content-Type.","supportedMediaTypes":["application/json","application/xml"]}`+"\n")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PATCH", "/", nil)
+
+	WriteUnsupportedMediaType(w, r, []string{"application/merge-patch+json"}, "")
+
+	assertEqual(t, w.Header().Get("Accept-Patch"), "application/merge-patch+json")
+	assertEqual(t, w.Header().Get("Accept-Post"), "")
+}
+
+func TestWriteUnsupportedMediaTypeEmpty(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", nil)
+
+	WriteUnsupportedMediaType(w, r, nil, "")
+
+	assertEqual(t, w.Code, http.StatusUnsupportedMediaType)
+	assertEqual(t, w.Header().Get("Accept-Post"), "")
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":415,"title":"Unsupported Media Type"}`+"\n")
+}
+
+func TestWriteStrictCharset(t *testing.T) {
+	pdw := &Writer{}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Charset", "iso-8859-1")
+
+	pdw.Write(w, r, http.StatusNotFound, "", "")
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+
+	pdw.StrictCharset = true
+	w = httptest.NewRecorder()
+
+	pdw.Write(w, r, http.StatusNotFound, "", "")
+
+	assertEqual(t, w.Code, http.StatusNotAcceptable)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":406,"title":"Not Acceptable","detail":"The response can only be encoded in UTF-8, which the Accept-Charset header excludes."}`+"\n")
+
+	w = httptest.NewRecorder()
+	r.Header.Set("Accept-Charset", "iso-8859-1, utf-8;q=0.5")
+
+	pdw.Write(w, r, http.StatusNotFound, "", "")
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+}
+
+func TestWriteTruncation(t *testing.T) {
+	pdw := &Writer{MaxTitleLen: 10, MaxDetailLen: 8}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	pdw.WriteProblem(w, r, &ProblemDetails{Status: http.StatusBadRequest, Title: "Invalid\r\nuser\x00 näme", Detail: "Ünknown field \"x\"."})
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Invalid  …","detail":"Ünknown…"}`+"\n")
+
+	w = httptest.NewRecorder()
+
+	WriteProblem(w, r, &ProblemDetails{Status: http.StatusBadRequest, Title: "Invalid\tuser", Detail: strings.Repeat("a", 1000)})
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Title, "Invalid user")
+	assertEqual(t, len(pd.Detail), 1000)
+}
+
+func TestWritePayloadTooLarge(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", nil)
+	w.Header().Set("Retry-After", "120")
+
+	WritePayloadTooLarge(w, r, 1<<20, "The request body is too large.")
+
+	assertEqual(t, w.Code, http.StatusRequestEntityTooLarge)
+	assertEqual(t, w.Header().Get("Retry-After"), "120")
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":413,"title":"Request Entity Too Large","detail":"The request body is too large.","maxBytes":1048576}`+"\n")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", 11)))
+	_, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 10))
+
+	WriteError(w, r, fmt.Errorf("reading body: %w", err))
+
+	assertEqual(t, w.Code, http.StatusRequestEntityTooLarge)
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":413,"title":"Request Entity Too Large","maxBytes":10}`+"\n")
+
+	pd := FromError(err)
+	var maxBytesErr *http.MaxBytesError
+	assertEqual(t, errors.As(pd, &maxBytesErr), true)
+
+	pd = FromError(errors.Join(err, &ProblemDetails{Status: http.StatusBadRequest}))
+	assertEqual(t, pd.Status, http.StatusBadRequest)
+	assertEqual(t, pd.Errors, []Error{{Detail: "Request Entity Too Large"}, {Detail: "Bad Request"}})
+}
+
+func TestWriteStrictAccept(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		pdw := &Writer{StrictAccept: strict}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", "application/pdf")
+
+		pdw.Write(w, r, http.StatusNotFound, "", "")
+
+		assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+		if !strict {
+			assertEqual(t, w.Code, http.StatusNotFound)
+			continue
+		}
+		assertEqual(t, w.Code, http.StatusNotAcceptable)
+		assertEqual(t, w.Body.String(), `{"type":"about:blank","status":406,"title":"Not Acceptable","detail":"The response can only be served as application/problem+json or application/json, which the Accept header excludes."}`+"\n")
+
+		w = httptest.NewRecorder()
+		r.Header.Set("Accept", "application/pdf, application/json;q=0.1")
+
+		pdw.Write(w, r, http.StatusNotFound, "", "")
+
+		assertEqual(t, w.Code, http.StatusNotFound)
+	}
+
+	c := NewConfig(WithWriter(&Writer{StrictAccept: true}), WithFormat(FormatXML))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/problem+xml")
+
+	c.Write(w, r, http.StatusNotFound, "", "")
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+xml; charset=utf-8")
+
+	w = httptest.NewRecorder()
+	r.Header.Set("Accept", "application/json")
+
+	c.Write(w, r, http.StatusNotFound, "", "")
+
+	assertEqual(t, w.Code, http.StatusNotAcceptable)
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assertEqual(t, w.Body.String(), `{"type":"about:blank","status":406,"title":"Not Acceptable","detail":"The response can only be served as application/problem+xml, application/xml or text/xml, which the Accept header excludes."}`+"\n")
+}
+
+func TestWriteCacheControl(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	Write(w, r, http.StatusInternalServerError, "", "")
+
+	assertEqual(t, w.Header().Get("Cache-Control"), "no-store")
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Cache-Control", "max-age=3600")
+
+	Write(w, r, http.StatusUnavailableForLegalReasons, "", "")
+
+	assertEqual(t, w.Header().Get("Cache-Control"), "max-age=3600")
+
+	w = httptest.NewRecorder()
+	pdw := &Writer{CacheControl: "private, no-cache"}
+
+	pdw.Write(w, r, http.StatusInternalServerError, "", "")
+
+	assertEqual(t, w.Header().Get("Cache-Control"), "private, no-cache")
+}
+
+func TestWriteCacheable(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	WriteCacheable(w, r, http.StatusUnavailableForLegalReasons, "v1", "")
+
+	assertEqual(t, w.Code, http.StatusUnavailableForLegalReasons)
+	assertEqual(t, w.Header().Get("ETag"), `"v1"`)
+	assertEqual(t, w.Header().Get("Cache-Control"), "no-cache")
+	assertEqual(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+
+	for _, ifNoneMatch := range []string{`"v1"`, `W/"v1"`, `"v0", "v1"`, "*"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+
+		WriteCacheable(w, r, http.StatusUnavailableForLegalReasons, `W/"v1"`, "")
+
+		assertEqual(t, w.Code, http.StatusNotModified)
+		assertEqual(t, w.Header().Get("ETag"), `W/"v1"`)
+		assertEqual(t, w.Body.Len(), 0)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", `"v0"`)
+
+	WriteCacheable(w, r, http.StatusUnavailableForLegalReasons, `"v1"`, "")
+
+	assertEqual(t, w.Code, http.StatusUnavailableForLegalReasons)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+
+	WriteCacheable(w, r, http.StatusUnavailableForLegalReasons, `"v1"`, "")
+
+	assertEqual(t, w.Code, http.StatusUnavailableForLegalReasons)
+}
+
+func TestWriteProblem(t *testing.T) {
+	pdw := &Writer{GetRequestID: func(*http.Request) string { return "req" }}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	pd := &ProblemDetails{Status: http.StatusNotFound, Instance: "/users/1", RequestId: "explicit"}
+
+	pdw.WriteProblem(w, r, pd)
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","instance":"/users/1","requestId":"explicit"}`+"\n")
+
+	w = httptest.NewRecorder()
+	pd = &ProblemDetails{Type: "https://example.com/probs/out-of-credit", Status: http.StatusForbidden, Title: "You do not have enough credit."}
+
+	pdw.WriteProblem(w, r, pd)
+
+	assertEqual(t, w.Code, http.StatusForbidden)
+	assertEqual(t, w.Body.String(), `{"type":"https://example.com/probs/out-of-credit","status":403,"title":"You do not have enough credit.","requestId":"req"}`+"\n")
+}
+
+func TestWriteProblemCode(t *testing.T) {
+	pd := (&ProblemDetails{Status: http.StatusNotFound}).WithCode("USER_NOT_FOUND").WithCode("")
+	assertEqual(t, pd.Code, "USER_NOT_FOUND")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	WriteProblem(w, r, pd)
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/not-found","status":404,"title":"Not Found","code":"USER_NOT_FOUND"}`+"\n")
+}
+
+func TestWriteProblemLinks(t *testing.T) {
+	pd := (&ProblemDetails{Status: http.StatusBadRequest}).
+		WithLink("help", "https://example.com/docs/errors").
+		WithLink("describedby", "https://example.com/schema.json")
+	pd.Links[1].Title = `The "schema"`
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	WriteProblem(w, r, pd)
+
+	assertEqual(t, w.Header().Values("Link"), []string(nil))
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","links":[{"href":"https://example.com/docs/errors","rel":"help"},{"href":"https://example.com/schema.json","rel":"describedby","title":"The \"schema\""}]}`+"\n")
+
+	w = httptest.NewRecorder()
+
+	(&Writer{LinkHeader: true}).WriteProblem(w, r, pd)
+
+	assertEqual(t, w.Header().Values("Link"), []string{
+		`<https://example.com/docs/errors>; rel="help"`,
+		`<https://example.com/schema.json>; rel="describedby"; title="The \"schema\""`,
+	})
+
+	link := Link{Href: "https://example.com/a>, <https://evil.example>; rel=\"x\" b", Rel: "help"}
+	assertEqual(t, link.String(), `<https://example.com/a%3E%2C%20%3Chttps://evil.example%3E;%20rel=%22x%22%20b>; rel="help"`)
+}
+
+func TestWriteProblemInvalidParams(t *testing.T) {
+	pd := (&ProblemDetails{Status: http.StatusBadRequest}).
+		AddInvalidParam("age", "must be a positive integer").
+		AddInvalidParam("color", "must be 'green', 'red' or 'blue'")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	WriteProblem(w, r, pd)
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","invalid-params":[{"name":"age","reason":"must be a positive integer"},{"name":"color","reason":"must be 'green', 'red' or 'blue'"}]}`+"\n")
+
+	w = httptest.NewRecorder()
+
+	WriteProblem(w, r, (&ProblemDetails{Status: http.StatusBadRequest}).WithInvalidParams([]InvalidParam{}).WithCode("INVALID"))
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","code":"INVALID"}`+"\n")
+
+	pd = &ProblemDetails{}
+	if err := json.Unmarshal([]byte(`{"status":400,"invalid-params":[{"name":"age","reason":"too young"}]}`), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.InvalidParams, []InvalidParam{{Name: "age", Reason: "too young"}})
+	assertEqual(t, pd.Extensions, map[string]any(nil))
+}
+
+func TestRegisterStatusText(t *testing.T) {
+	assertEqual(t, StatusText(499), "")
+
+	RegisterStatusText(499, "Client Closed Request")
+	defer func() {
+		statusTextsMu.Lock()
+		delete(statusTexts, 499)
+		statusTextsMu.Unlock()
+	}()
+
+	assertEqual(t, StatusText(499), "Client Closed Request")
+	assertEqual(t, StatusText(http.StatusNotFound), "Not Found")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	Write(w, r, 499, "", "")
+
+	pd := &ProblemDetails{}
+	if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pd.Status, 499)
+	assertEqual(t, pd.Title, "Client Closed Request")
+}
+
+func TestWriteBaseURI(t *testing.T) {
+	for typeUri, want := range map[string]string{
+		"/probs/out-of-credit":                    "https://example.com/probs/out-of-credit",
+		"out-of-credit":                           "https://example.com/api/out-of-credit",
+		"https://other.example/probs/out-of-cred": "https://other.example/probs/out-of-cred",
+		"about:blank":                             "about:blank",
+		"":                                        "about:blank",
+		"%zz":                                     "%zz",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		(&Writer{BaseURI: "https://example.com/api/"}).WriteProblem(w, r, &ProblemDetails{Type: typeUri, Status: http.StatusConflict})
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, pd.Type, want)
+	}
+
+	assertEqual(t, resolveTypeUri("/not/absolute", "/probs/foo"), "/probs/foo")
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	WriteError(w, r, fs.ErrNotExist)
+
+	assertEqual(t, w.Code, http.StatusInternalServerError)
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/server-error","status":500,"title":"Internal Server Error"}`+"\n")
+
+	w = httptest.NewRecorder()
+
+	WriteError(w, r, errors.Join(fs.ErrNotExist, &ProblemDetails{Status: http.StatusNotFound}))
+
+	assertEqual(t, w.Code, http.StatusNotFound)
+}
+
+func TestWriteErrorSentinel(t *testing.T) {
+	errNotFound := &ProblemDetails{Status: http.StatusNotFound, Extensions: map[string]any{"resource": "user"}}
+	pdw := &Writer{
+		GetRequestID:      func(r *http.Request) string { return r.Header.Get("X-Request-Id") },
+		DefaultExtensions: map[string]any{"service": "users"},
+	}
+
+	for _, id := range []string{"a", "b"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Id", id)
+
+		pdw.WriteError(w, r, fmt.Errorf("getting user: %w", errNotFound))
+
+		pd := &ProblemDetails{}
+		if err := json.Unmarshal(w.Body.Bytes(), pd); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, pd.RequestId, id)
+		assertEqual(t, pd.Extensions, map[string]any{"resource": "user", "service": "users"})
+	}
+
+	assertEqual(t, errNotFound, &ProblemDetails{Status: http.StatusNotFound, Extensions: map[string]any{"resource": "user"}})
+}
+
+func TestFromErrorJoined(t *testing.T) {
+	missingName := NewBodyError("/name", "Missing name.", "")
+	invalidAge := NewBodyError("/age", "Invalid age.", "")
+
+	pd := FromError(errors.Join(
+		&ProblemDetails{Status: http.StatusBadRequest, Errors: []Error{missingName}},
+		fs.ErrNotExist,
+		&ProblemDetails{Status: http.StatusBadRequest, Detail: "Invalid user.", Errors: []Error{invalidAge}},
+	))
+
+	assertEqual(t, pd.Status, http.StatusBadRequest)
+	assertEqual(t, pd.Detail, "")
+	assertEqual(t, pd.Errors, []Error{missingName, invalidAge})
+
+	err := errors.Join(
+		&ProblemDetails{Status: http.StatusNotFound, Detail: "No such user.", Code: "USER_NOT_FOUND"},
+		errors.Join(&ProblemDetails{Status: http.StatusConflict, Errors: []Error{missingName}}),
+	)
+	pd = FromError(err)
+
+	assertEqual(t, pd.Status, http.StatusBadRequest)
+	assertEqual(t, pd.Errors, []Error{{Detail: "No such user.", Code: "USER_NOT_FOUND"}, missingName})
+	assertEqual(t, pd.Unwrap(), err)
+
+	pd = FromError(errors.Join(&ProblemDetails{Status: http.StatusNotFound}, &ProblemDetails{Status: http.StatusServiceUnavailable}))
+
+	assertEqual(t, pd.Status, http.StatusInternalServerError)
+	assertEqual(t, pd.Errors, []Error{{Detail: "Not Found"}, {Detail: "Service Unavailable"}})
+
+	err = errors.Join(fs.ErrNotExist, fs.ErrPermission)
+	pd = FromError(err)
+
+	assertEqual(t, pd.Status, http.StatusInternalServerError)
+	assertEqual(t, pd.Errors, []Error(nil))
+	assertEqual(t, pd.Unwrap(), err)
+}
+
+func TestWriteDefaultExtensions(t *testing.T) {
+	pdw := &Writer{
+		BaseURI: "https://example.com/probs/",
+		TypeExtensions: map[string]map[string]any{
+			"https://example.com/probs/out-of-credit": {"docs": "https://example.com/docs/credit", "severity": "warning"},
+		},
+		StatusExtensions: map[int]map[string]any{
+			http.StatusForbidden: {"severity": "error", "retryable": false},
+		},
+		DefaultExtensions: map[string]any{"retryable": true, "service": "billing", "status": 500},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	shared := map[string]any{"docs": "https://example.com/docs/balance"}
+	pdw.WriteProblem(w, r, &ProblemDetails{Type: "out-of-credit", Status: http.StatusForbidden, Extensions: shared})
+
+	assertEqual(t, w.Body.String(), `{"type":"https://example.com/probs/out-of-credit","status":403,"title":"Forbidden","docs":"https://example.com/docs/balance","retryable":false,"service":"billing","severity":"warning"}`+"\n")
+	assertEqual(t, shared, map[string]any{"docs": "https://example.com/docs/balance"})
+
+	w = httptest.NewRecorder()
+
+	pdw.Write(w, r, http.StatusBadRequest, "", "")
+
+	assertEqual(t, w.Body.String(), `{"type":"https://problems-registry.smartbear.com/bad-request","status":400,"title":"Bad Request","retryable":true,"service":"billing"}`+"\n")
+	assertEqual(t, pdw.DefaultExtensions, map[string]any{"retryable": true, "service": "billing", "status": 500})
+}
+
+func BenchmarkWrite(b *testing.B) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		w.Body.Reset()
+		Write(w, r, http.StatusNotFound, "The requested resource was not found.", "NOT_FOUND")
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"errors"
+	"net/http"
+)
+
+// DefaultValidationStatus is the status used by ValidationProblem and FromValidationErrors when they are given a status of 0.
+const DefaultValidationStatus = http.StatusBadRequest
+
+// FieldError is an error for a request parameter that failed validation. FromValidationErrors turns it into an InvalidParam.
+type FieldError struct {
+	Name string // The name of the parameter.
+	Err  error  // Why the parameter is invalid. If nil, the reason is empty.
+}
+
+func (e *FieldError) Error() string {
+	if e.Err == nil {
+		return e.Name
+	}
+	return e.Name + ": " + e.Err.Error()
+}
+
+// reason returns the message of e.Err, or "" if it's nil.
+func (e *FieldError) reason() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationProblem returns a problem for a request that failed validation, with the given invalid parameters.
+//
+// status is usually http.StatusBadRequest or http.StatusUnprocessableEntity; if 0, DefaultValidationStatus is used.
+// The title is the status text of the resulting status.
+func ValidationProblem(status int, params ...InvalidParam) *ProblemDetails {
+	if status == 0 {
+		status = DefaultValidationStatus
+	}
+
+	pd := Problem(status)
+	if len(params) > 0 {
+		pd.InvalidParams = params
+	}
+	return pd
+}
+
+// FromValidationErrors is like ValidationProblem, but makes an InvalidParam from each *FieldError in err,
+// which may be a single *FieldError, wrap one, or be a joined error (as with errors.Join) of several.
+// err is set as the cause, but its message is never used as the detail (even if it contains no *FieldError), so that internal
+// error messages don't leak to the client. Set the detail explicitly if needed.
+func FromValidationErrors(status int, err error) *ProblemDetails {
+	pd := ValidationProblem(status)
+	pd.cause = err
+
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+
+	for _, err := range errs {
+		var fe *FieldError
+		if errors.As(err, &fe) {
+			pd.AddInvalidParam(fe.Name, fe.reason())
+		}
+	}
+
+	return pd
+}
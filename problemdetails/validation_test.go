@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidationProblem(t *testing.T) {
+	pd := ValidationProblem(0, InvalidParam{Name: "age", Reason: "too young"})
+	assertEqual(t, pd.Status, http.StatusBadRequest)
+	assertEqual(t, pd.Title, "Bad Request")
+	assertEqual(t, pd.InvalidParams, []InvalidParam{{Name: "age", Reason: "too young"}})
+
+	pd = ValidationProblem(http.StatusUnprocessableEntity)
+	assertEqual(t, pd.Status, http.StatusUnprocessableEntity)
+	assertEqual(t, pd.Title, "Unprocessable Entity")
+	assertEqual(t, pd.InvalidParams == nil, true)
+}
+
+func TestFromValidationErrors(t *testing.T) {
+	errTooYoung := errors.New("too young")
+	err := errors.Join(
+		&FieldError{Name: "age", Err: errTooYoung},
+		errors.New("unrelated"),
+		&FieldError{Name: "name", Err: errors.New("required")},
+	)
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnprocessableEntity} {
+		pd := FromValidationErrors(status, err)
+		assertEqual(t, pd.Status, status)
+		assertEqual(t, pd.Title, StatusText(status))
+		assertEqual(t, pd.Detail, "")
+		assertEqual(t, pd.InvalidParams, []InvalidParam{{Name: "age", Reason: "too young"}, {Name: "name", Reason: "required"}})
+		assertEqual(t, errors.Is(pd, errTooYoung), true)
+	}
+
+	pd := FromValidationErrors(0, errors.New("malformed body"))
+	assertEqual(t, pd.Status, http.StatusBadRequest)
+	assertEqual(t, pd.Detail, "")
+	assertEqual(t, pd.InvalidParams == nil, true)
+
+	nilErr := &FieldError{Name: "age"}
+	pd = FromValidationErrors(http.StatusUnprocessableEntity, nilErr)
+	assertEqual(t, pd.InvalidParams, []InvalidParam{{Name: "age", Reason: ""}})
+	assertEqual(t, nilErr.Error(), "age")
+}
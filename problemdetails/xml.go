@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// Copyright (c) 2025 sibber (GitHub: sibber5)
+
+package problemdetails
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+)
+
+// xmlNamespace is the namespace of problem details XML documents, as defined in RFC 9457 appendix B.
+const xmlNamespace = "urn:ietf:rfc:7807"
+
+// marshalXMLBody serializes pd in the XML format of RFC 9457 appendix B: a "problem" element with one child element per member
+// (in the same order as the JSON format), where arrays are serialized as "i" elements and objects as child elements.
+// Members whose names aren't valid XML names (e.g. "$schema") are omitted.
+func marshalXMLBody(pd *ProblemDetails) ([]byte, error) {
+	v, err := marshalOrdered(pd)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	if err := encodeXMLElement(enc, xml.Name{Space: xmlNamespace, Local: "problem"}, v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func encodeXMLElement(enc *xml.Encoder, name xml.Name, v any) error {
+	if err := enc.EncodeToken(xml.StartElement{Name: name}); err != nil {
+		return err
+	}
+
+	var err error
+	switch v := v.(type) {
+	case []member:
+		for _, m := range v {
+			if !validXMLName(m.name) {
+				continue
+			}
+			if err = encodeXMLElement(enc, xml.Name{Local: m.name}, m.value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err = encodeXMLElement(enc, xml.Name{Local: "i"}, item); err != nil {
+				return err
+			}
+		}
+	case string:
+		err = enc.EncodeToken(xml.CharData(v))
+	case json.Number:
+		err = enc.EncodeToken(xml.CharData(v.String()))
+	case bool:
+		if v {
+			err = enc.EncodeToken(xml.CharData("true"))
+		} else {
+			err = enc.EncodeToken(xml.CharData("false"))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return enc.EncodeToken(xml.EndElement{Name: name})
+}
+
+// validXMLName reports whether name is a valid XML element name without a namespace prefix.
+// Only ASCII names are accepted, which covers the members of ProblemDetails and typical extension members.
+func validXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}